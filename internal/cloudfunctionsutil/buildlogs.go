@@ -0,0 +1,146 @@
+package cloudfunctionsutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// buildLogTailSize is the number of lines kept by BuildLogs for Tail.
+const buildLogTailSize = 20
+
+// BuildNameFromMetadata returns the name of the Cloud Build build of a function
+// from the metadata of its operation, in the format
+// projects/{project}/locations/{location}/builds/{id}. Both 1st and 2nd gen
+// operations are supported. It returns an empty string if the build hasn't
+// started yet.
+func BuildNameFromMetadata(metadata googleapi.RawMessage) string {
+	var md struct {
+		// BuildName is set for 1st gen functions.
+		BuildName string `json:"buildName"`
+
+		// Stages are set for 2nd gen functions.
+		Stages []struct {
+			Name     string `json:"name"`
+			Resource string `json:"resource"`
+		} `json:"stages"`
+	}
+
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		return ""
+	}
+
+	if md.BuildName != "" {
+		return md.BuildName
+	}
+
+	for _, s := range md.Stages {
+		if s.Name == "BUILD" && strings.Contains(s.Resource, "/builds/") {
+			return s.Resource
+		}
+	}
+
+	return ""
+}
+
+// BuildLogs incrementally reads the logs of a Cloud Build build from Cloud
+// Logging.
+type BuildLogs struct {
+	service *logging.Service
+
+	project  string
+	location string
+	buildID  string
+
+	// since is the timestamp of the last entry read, and seen the insert
+	// IDs of the entries read with that timestamp.
+	since string
+	seen  map[string]bool
+
+	tail []string
+}
+
+// NewBuildLogs returns a reader for the logs of the build, in the format
+// returned by BuildNameFromMetadata.
+func NewBuildLogs(ctx context.Context, buildName string) (*BuildLogs, error) {
+	parts := strings.Split(buildName, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "builds" {
+		return nil, fmt.Errorf("invalid build name %q", buildName)
+	}
+
+	service, err := logging.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BuildLogs{
+		service:  service,
+		project:  parts[1],
+		location: parts[3],
+		buildID:  parts[5],
+		seen:     map[string]bool{},
+	}, nil
+}
+
+// URL returns the link to the build in the Google Cloud Console.
+func (l *BuildLogs) URL() string {
+	return fmt.Sprintf(
+		"https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s",
+		l.location, l.buildID, l.project,
+	)
+}
+
+// Next returns the log lines written since the previous call.
+func (l *BuildLogs) Next(ctx context.Context) ([]string, error) {
+	filter := fmt.Sprintf(`resource.type="build" AND resource.labels.build_id="%s"`, l.buildID)
+	if l.since != "" {
+		filter += fmt.Sprintf(` AND timestamp>="%s"`, l.since)
+	}
+
+	var lines []string
+
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + l.project},
+		Filter:        filter,
+		OrderBy:       "timestamp asc",
+		PageSize:      1000,
+	}
+
+	err := l.service.Entries.List(req).Pages(ctx, func(resp *logging.ListLogEntriesResponse) error {
+		for _, e := range resp.Entries {
+			if e.Timestamp != l.since {
+				l.since = e.Timestamp
+				l.seen = map[string]bool{}
+			}
+
+			if l.seen[e.InsertId] {
+				continue
+			}
+			l.seen[e.InsertId] = true
+
+			line := strings.TrimRight(e.TextPayload, "\n")
+			lines = append(lines, line)
+
+			l.tail = append(l.tail, line)
+			if len(l.tail) > buildLogTailSize {
+				l.tail = l.tail[len(l.tail)-buildLogTailSize:]
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// Tail returns the last lines read.
+func (l *BuildLogs) Tail() []string {
+	return l.tail
+}
@@ -2,30 +2,181 @@ package cloudfunctionsutil
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
 	"time"
 
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
 	"google.golang.org/api/cloudfunctions/v1"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+	"google.golang.org/api/googleapi"
 )
 
+// DefaultOperationTimeout is the time after which waiting for an operation
+// is abandoned when WaitOptions doesn't set a timeout.
+const DefaultOperationTimeout = 20 * time.Minute
+
+// Bounds of the delay between two polls of an operation. The delay starts at
+// initialPollInterval and grows exponentially up to maxPollInterval.
+const (
+	initialPollInterval = 1 * time.Second
+	maxPollInterval     = 15 * time.Second
+	pollMultiplier      = 1.5
+	pollJitter          = 0.2
+)
+
+// WaitOptions configures how an operation is waited for.
+type WaitOptions struct {
+	// Timeout is the maximum duration to wait for the operation.
+	// Defaults to DefaultOperationTimeout.
+	Timeout time.Duration
+
+	// Status, if set, is updated with Message and the elapsed time
+	// while waiting.
+	Status  terminal.Status
+	Message string
+
+	// Progress, if set, is called with the metadata of the operation
+	// after each poll.
+	Progress func(ctx context.Context, metadata googleapi.RawMessage)
+}
+
 // WaitForOperation keeps polling long the operation until it finishes either
 // successfully or with an error.
 func WaitForOperation(
 	ctx context.Context,
 	service *cloudfunctions.Service,
 	op *cloudfunctions.Operation,
+	opts WaitOptions,
 ) (*cloudfunctions.Operation, error) {
-	var err error
+	err := poll(ctx, opts, func(ctx context.Context) (bool, error) {
+		if op.Done {
+			return true, nil
+		}
+
+		resp, err := service.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+
+		op = resp
+
+		if opts.Progress != nil {
+			opts.Progress(ctx, op.Metadata)
+		}
+
+		return op.Done, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	for !op.Done {
-		opCall := service.Operations.Get(op.Name)
-		opCall = opCall.Context(ctx)
-		op, err = opCall.Do()
+	return op, nil
+}
+
+// WaitForOperationV2 is the equivalent of WaitForOperation for operations
+// of 2nd gen functions.
+func WaitForOperationV2(
+	ctx context.Context,
+	service *cloudfunctionsv2.Service,
+	op *cloudfunctionsv2.Operation,
+	opts WaitOptions,
+) (*cloudfunctionsv2.Operation, error) {
+	err := poll(ctx, opts, func(ctx context.Context) (bool, error) {
+		if op.Done {
+			return true, nil
+		}
+
+		resp, err := service.Projects.Locations.Operations.Get(op.Name).Context(ctx).Do()
 		if err != nil {
-			return nil, err
+			return false, err
+		}
+
+		op = resp
+
+		if opts.Progress != nil {
+			opts.Progress(ctx, op.Metadata)
 		}
 
-		time.Sleep(1 * time.Second)
+		return op.Done, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return op, nil
 }
+
+// poll calls check with an exponential backoff until it reports being done,
+// returns a non transient error, the context is canceled or the timeout is
+// reached.
+func poll(ctx context.Context, opts WaitOptions, check func(ctx context.Context) (bool, error)) error {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	interval := initialPollInterval
+
+	for {
+		done, err := check(ctx)
+		if err != nil && !isTransient(err) {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("operation not done after %s: %w", timeout, err)
+			}
+
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		if opts.Status != nil {
+			elapsed := time.Since(start).Round(time.Second)
+			opts.Status.Update(fmt.Sprintf("%s (%s elapsed)", opts.Message, elapsed))
+		}
+
+		timer := time.NewTimer(jitter(interval))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("operation not done after %s", timeout)
+			}
+
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		interval = time.Duration(float64(interval) * pollMultiplier)
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// jitter randomizes d by up to pollJitter in both directions, so that
+// concurrent deployments don't poll in lockstep.
+func jitter(d time.Duration) time.Duration {
+	delta := pollJitter * float64(d)
+	return time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+}
+
+// isTransient reports whether err is an API error worth retrying.
+func isTransient(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+
+	return gerr.Code == http.StatusTooManyRequests || gerr.Code >= http.StatusInternalServerError
+}
@@ -0,0 +1,117 @@
+package cloudfunctionsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{errors.New("connection reset"), false},
+	}
+
+	for _, tc := range cases {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("isTransient(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestPollDone(t *testing.T) {
+	calls := 0
+
+	err := poll(context.Background(), WaitOptions{}, func(ctx context.Context) (bool, error) {
+		calls++
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestPollRetriesTransientErrors(t *testing.T) {
+	calls := 0
+
+	err := poll(context.Background(), WaitOptions{}, func(ctx context.Context) (bool, error) {
+		calls++
+		if calls == 1 {
+			return false, &googleapi.Error{Code: http.StatusServiceUnavailable}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+}
+
+func TestPollStopsOnPermanentErrors(t *testing.T) {
+	forbidden := &googleapi.Error{Code: http.StatusForbidden}
+	calls := 0
+
+	err := poll(context.Background(), WaitOptions{}, func(ctx context.Context) (bool, error) {
+		calls++
+		return false, forbidden
+	})
+	if !errors.Is(err, forbidden) {
+		t.Errorf("err = %v, want %v", err, forbidden)
+	}
+
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	err := poll(context.Background(), WaitOptions{Timeout: 10 * time.Millisecond}, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "not done after 10ms") {
+		t.Errorf("err = %v, want a timeout error", err)
+	}
+}
+
+func TestPollCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := poll(ctx, WaitOptions{}, func(ctx context.Context) (bool, error) {
+		cancel()
+		return false, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		got := jitter(time.Second)
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s) = %s, want within 20%%", got)
+		}
+	}
+}
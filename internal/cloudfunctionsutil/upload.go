@@ -0,0 +1,236 @@
+package cloudfunctionsutil
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Retries of the archive upload. The delay between two attempts starts at
+// initialUploadBackoff and doubles up to maxUploadBackoff.
+const (
+	maxUploadAttempts    = 5
+	initialUploadBackoff = 1 * time.Second
+	maxUploadBackoff     = 30 * time.Second
+
+	// UploadAttemptTimeout bounds the duration of a single upload attempt.
+	UploadAttemptTimeout = 10 * time.Minute
+)
+
+// MaxArchiveSize is the maximum size of the archives accepted by the signed
+// upload URLs.
+const MaxArchiveSize = 104857600
+
+// ArchiveContentType is the content type of the uploaded archives.
+const ArchiveContentType = "application/zip"
+
+// UploadClient is the HTTP client used to transfer archives to and from signed
+// URLs. Unlike http.DefaultClient it sets timeouts on connections, and it uses
+// the proxy configured by the HTTPS_PROXY and NO_PROXY environment variables.
+var UploadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+	},
+}
+
+// ArchiveHashes are the hashes of an archive.
+type ArchiveHashes struct {
+	// SHA256 is the hex encoded SHA-256 digest, used to address archives.
+	SHA256 string
+
+	// MD5 and CRC32C are the base64 encoded hashes used by Cloud Storage to
+	// check the integrity of objects.
+	MD5    string
+	CRC32C string
+}
+
+// HashFile returns the hashes of the content of file, which is rewound.
+func HashFile(file *os.File) (ArchiveHashes, error) {
+	var hashes ArchiveHashes
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return hashes, err
+	}
+
+	sha := sha256.New()
+	md := md5.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+
+	if _, err := io.Copy(io.MultiWriter(sha, md, crc), file); err != nil {
+		return hashes, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return hashes, err
+	}
+
+	crcBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBytes, crc.Sum32())
+
+	hashes.SHA256 = hex.EncodeToString(sha.Sum(nil))
+	hashes.MD5 = base64.StdEncoding.EncodeToString(md.Sum(nil))
+	hashes.CRC32C = base64.StdEncoding.EncodeToString(crcBytes)
+
+	return hashes, nil
+}
+
+// UploadOptions configures UploadToURL.
+type UploadOptions struct {
+	// Log, if set, logs the failed attempts which are retried.
+	Log hclog.Logger
+
+	// Attempt, if set, is called at the start of each attempt with the
+	// archive and returns the reader to upload, e.g. to report the progress.
+	Attempt func(r io.Reader) io.Reader
+}
+
+// UploadToURL uploads the archive to a signed upload URL, retrying with an
+// exponential backoff on network errors and server errors.
+func UploadToURL(
+	ctx context.Context,
+	uploadURL string,
+	file *os.File,
+	size int64,
+	hashes ArchiveHashes,
+	opts UploadOptions,
+) error {
+	backoff := initialUploadBackoff
+
+	for attempt := 1; ; attempt++ {
+		var body io.Reader = io.NewSectionReader(file, 0, size)
+		if opts.Attempt != nil {
+			body = opts.Attempt(body)
+		}
+
+		retry, err := uploadAttempt(ctx, uploadURL, body, size, hashes)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt == maxUploadAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		if opts.Log != nil {
+			opts.Log.Warn("archive upload failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > maxUploadBackoff {
+			backoff = maxUploadBackoff
+		}
+	}
+}
+
+// uploadAttempt uploads the archive once, and reports whether the upload
+// should be retried when it fails.
+func uploadAttempt(
+	ctx context.Context,
+	uploadURL string,
+	body io.Reader,
+	size int64,
+	hashes ArchiveHashes,
+) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, UploadAttemptTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, body)
+	if err != nil {
+		return false, err
+	}
+
+	// The headers of the request are part of the signature of the URL, the
+	// integrity of the upload is checked with the hashes returned by Cloud
+	// Storage instead of sending Content-MD5 or x-goog-hash.
+	req.ContentLength = size
+	req.Header.Add("content-type", ArchiveContentType)
+	req.Header.Add("x-goog-content-length-range", fmt.Sprintf("0,%d", MaxArchiveSize))
+
+	resp, err := UploadClient.Do(req)
+	if err != nil {
+		// Network errors and timeouts of the attempt are retried.
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	if resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode >= http.StatusInternalServerError
+
+		return retry, errors.New(resp.Status + "\n\n" + string(respBody))
+	}
+
+	if err := checkHashes(resp.Header.Values("x-goog-hash"), hashes); err != nil {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// checkHashes compares the hashes of the uploaded object, in the format of
+// the x-goog-hash header, e.g. "crc32c=n03x6A==", with the ones of the
+// archive.
+func checkHashes(values []string, hashes ArchiveHashes) error {
+	for _, value := range values {
+		for _, h := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(h), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			var expected string
+			switch parts[0] {
+			case "md5":
+				expected = hashes.MD5
+			case "crc32c":
+				expected = hashes.CRC32C
+			default:
+				continue
+			}
+
+			if parts[1] != expected {
+				return fmt.Errorf("uploaded archive is corrupted, %s is %s instead of %s", parts[0], parts[1], expected)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package cloudfunctionsutil
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// archive is the content of the archive uploaded by the tests, with its
+// base64 encoded MD5 and CRC32C hashes.
+const (
+	archive       = "archive content"
+	archiveMD5    = "dzhSWOTrxRadtnABravarw=="
+	archiveCRC32C = "0bVvwg=="
+)
+
+// tempArchive returns a file containing archive and its hashes.
+func tempArchive(t *testing.T) (*os.File, ArchiveHashes) {
+	t.Helper()
+
+	file, err := ioutil.TempFile(t.TempDir(), "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	if _, err := file.WriteString(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := HashFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file, hashes
+}
+
+// uploadServer is a signed upload URL answering each request with the next
+// status of statuses, OK once they are exhausted, and the hashes of archive.
+type uploadServer struct {
+	*httptest.Server
+
+	statuses []int
+	bodies   []string
+}
+
+func newUploadServer(t *testing.T, statuses ...int) *uploadServer {
+	s := &uploadServer{statuses: statuses}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+
+		if r.Method != http.MethodPut || r.Header.Get("content-type") != ArchiveContentType {
+			t.Errorf("unexpected %s request with content type %q", r.Method, r.Header.Get("content-type"))
+		}
+
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+
+		w.Header().Add("x-goog-hash", "crc32c="+archiveCRC32C)
+		w.Header().Add("x-goog-hash", "md5="+archiveMD5)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestHashFile(t *testing.T) {
+	_, hashes := tempArchive(t)
+
+	if hashes.MD5 != archiveMD5 || hashes.CRC32C != archiveCRC32C {
+		t.Errorf("hashes = %+v", hashes)
+	}
+
+	if len(hashes.SHA256) != 64 {
+		t.Errorf("SHA256 = %q is not hex encoded", hashes.SHA256)
+	}
+}
+
+func TestCheckHashes(t *testing.T) {
+	hashes := ArchiveHashes{MD5: archiveMD5, CRC32C: archiveCRC32C}
+
+	cases := []struct {
+		name    string
+		values  []string
+		wantErr bool
+	}{
+		{name: "no header"},
+		{name: "separate headers", values: []string{"crc32c=" + archiveCRC32C, "md5=" + archiveMD5}},
+		{name: "single header", values: []string{"crc32c=" + archiveCRC32C + ", md5=" + archiveMD5}},
+		{name: "unknown hash", values: []string{"sha1=abc", "crc32c=" + archiveCRC32C}},
+		{name: "crc32c mismatch", values: []string{"crc32c=AAAAAA==", "md5=" + archiveMD5}, wantErr: true},
+		{name: "md5 mismatch", values: []string{"crc32c=" + archiveCRC32C + ",md5=AAAAAAAAAAAAAAAAAAAAAA=="}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkHashes(tc.values, hashes)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkHashes() error = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploadAttemptRetriedStatuses(t *testing.T) {
+	cases := []struct {
+		status int
+		retry  bool
+	}{
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+	}
+
+	file, hashes := tempArchive(t)
+
+	for _, tc := range cases {
+		srv := newUploadServer(t, tc.status)
+
+		body := io.NewSectionReader(file, 0, int64(len(archive)))
+
+		retry, err := uploadAttempt(context.Background(), srv.URL, body, int64(len(archive)), hashes)
+		if err == nil {
+			t.Errorf("status %d: no error", tc.status)
+		}
+
+		if retry != tc.retry {
+			t.Errorf("status %d: retry = %t, want %t", tc.status, retry, tc.retry)
+		}
+	}
+}
+
+func TestUploadAttemptCorrupted(t *testing.T) {
+	file, hashes := tempArchive(t)
+	srv := newUploadServer(t)
+
+	hashes.CRC32C = "AAAAAA=="
+
+	body := io.NewSectionReader(file, 0, int64(len(archive)))
+
+	retry, err := uploadAttempt(context.Background(), srv.URL, body, int64(len(archive)), hashes)
+	if err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("err = %v, want a corrupted archive error", err)
+	}
+
+	if !retry {
+		t.Error("corrupted upload is not retried")
+	}
+}
+
+func TestUploadToURLRetries(t *testing.T) {
+	file, hashes := tempArchive(t)
+	srv := newUploadServer(t, http.StatusServiceUnavailable, http.StatusOK)
+
+	attempts := 0
+	opts := UploadOptions{
+		Log: hclog.NewNullLogger(),
+		Attempt: func(r io.Reader) io.Reader {
+			attempts++
+			return r
+		},
+	}
+
+	if err := UploadToURL(context.Background(), srv.URL, file, int64(len(archive)), hashes, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+
+	// Each attempt sends the whole archive again.
+	if want := []string{archive, archive}; !reflect.DeepEqual(srv.bodies, want) {
+		t.Errorf("received %q, want %q", srv.bodies, want)
+	}
+}
+
+func TestUploadToURLDoesNotRetryForbidden(t *testing.T) {
+	file, hashes := tempArchive(t)
+	srv := newUploadServer(t, http.StatusForbidden, http.StatusOK)
+
+	err := UploadToURL(context.Background(), srv.URL, file, int64(len(archive)), hashes, UploadOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "403") {
+		t.Errorf("err = %v, want the 403 error", err)
+	}
+
+	if len(srv.bodies) != 1 {
+		t.Errorf("got %d attempts, want 1", len(srv.bodies))
+	}
+}
+
+func TestUploadToURLCanceled(t *testing.T) {
+	file, hashes := tempArchive(t)
+	srv := newUploadServer(t, http.StatusServiceUnavailable, http.StatusOK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := UploadToURL(ctx, srv.URL, file, int64(len(archive)), hashes, UploadOptions{}); err == nil {
+		t.Error("no error with a canceled context")
+	}
+}
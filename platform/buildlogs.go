@@ -0,0 +1,88 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/googleapi"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
+)
+
+// buildLogStreamer streams the Cloud Build logs of the function being
+// deployed to the terminal while waiting for the deploy operation.
+type buildLogStreamer struct {
+	log hclog.Logger
+	st  terminal.Status
+
+	logs *cloudfunctionsutil.BuildLogs
+
+	// disabled is set when the logs can't be read, e.g. because of missing
+	// permissions, so that the deploy is not slowed down by failing calls.
+	disabled bool
+}
+
+// progress implements cloudfunctionsutil.WaitOptions.Progress.
+func (s *buildLogStreamer) progress(ctx context.Context, metadata googleapi.RawMessage) {
+	if s.disabled {
+		return
+	}
+
+	if s.logs == nil {
+		buildName := cloudfunctionsutil.BuildNameFromMetadata(metadata)
+		if buildName == "" {
+			return
+		}
+
+		logs, err := cloudfunctionsutil.NewBuildLogs(ctx, buildName)
+		if err != nil {
+			s.disable(err)
+			return
+		}
+
+		s.logs = logs
+		s.st.Step(terminal.StatusOK, "Build logs available at "+logs.URL())
+	}
+
+	lines, err := s.logs.Next(ctx)
+	if err != nil {
+		s.disable(err)
+		return
+	}
+
+	// The UI can't be written to while the status is open. Each line is an
+	// update of the status: non-interactive terminals print every update,
+	// interactive ones show the latest line until the next poll.
+	for _, line := range lines {
+		s.st.Update(line)
+	}
+}
+
+func (s *buildLogStreamer) disable(err error) {
+	s.disabled = true
+	s.log.Warn("unable to stream the build logs", "error", err)
+	s.st.Step(terminal.StatusWarn, "Unable to stream the build logs: "+err.Error())
+}
+
+// buildError returns the error of a failed build, including the link to the
+// build and the last lines of its logs when available.
+func (s *buildLogStreamer) buildError(message string) error {
+	if s.logs == nil {
+		return errors.New(message)
+	}
+
+	var b strings.Builder
+	b.WriteString(message)
+	b.WriteString("\n\nBuild logs: ")
+	b.WriteString(s.logs.URL())
+
+	if tail := s.logs.Tail(); len(tail) > 0 {
+		b.WriteString("\n\n")
+		b.WriteString(strings.Join(tail, "\n"))
+	}
+
+	return errors.New(b.String())
+}
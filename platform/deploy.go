@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/waypoint-plugin-sdk/component"
 	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
 	"google.golang.org/api/cloudfunctions/v1"
@@ -16,12 +20,41 @@ import (
 )
 
 type DeployConfig struct {
+	// Name of the function, a Go template executed with the App and
+	// Workspace, e.g. "{{.App}}-{{.Workspace}}". Defaults to "{{.App}}".
+	Name string `hcl:"name,optional"`
+
+	// Workspaces overrides the name of the function for Waypoint
+	// workspaces.
+	Workspaces []workspaceConfig `hcl:"workspace,block"`
+
 	// EnvironmentVariables that shall be available during function execution.
+	// The variables provided by Waypoint are added at deploy time, see
+	// withWaypointEnv.
 	EnvironmentVariables map[string]string `hcl:"environment_variables,optional"`
 
 	// BuildEnvironmentVariables that shall be available during build time.
 	BuildEnvironmentVariables map[string]string `hcl:"build_environment_variables,optional"`
 
+	// EnvironmentVariablesFile is the path, relative to the app, of a dotenv
+	// file or of a YAML file (.yaml or .yml) in the format of
+	// `gcloud functions deploy --env-vars-file` defining environment
+	// variables. EnvironmentVariables take precedence over the file.
+	EnvironmentVariablesFile string `hcl:"environment_variables_file,optional"`
+
+	// BuildEnvironmentVariablesFile is the equivalent of
+	// EnvironmentVariablesFile for BuildEnvironmentVariables.
+	BuildEnvironmentVariablesFile string `hcl:"build_environment_variables_file,optional"`
+
+	// SecretEnvironmentVariables maps environment variables to Secret Manager
+	// secret versions, in the format `{secret}:{version}` or
+	// `projects/{project}/secrets/{secret}/versions/{version}`. Only the
+	// references are sent, the values of the secrets are never read.
+	SecretEnvironmentVariables map[string]string `hcl:"secret_environment_variables,optional"`
+
+	// SecretVolumes mount Secret Manager secrets as files.
+	SecretVolumes []secretVolume `hcl:"secret_volumes,block"`
+
 	// MaxInstances sets the maximum number of instances for the function.
 	// A function execution that would exceed max-instances times out.
 	MaxInstances int64 `hcl:"max_instances,optional"`
@@ -76,10 +109,11 @@ type DeployConfig struct {
 
 	// EventTrigger is  the source that fires events in response to a condition
 	// in another service.
-	// Cannot be used with TriggerHTTP.
+	// Cannot be used with TriggerHTTP or Staged.
 	EventTrigger *eventTrigger `hcl:"event_trigger,block"`
 
-	// Labels associated with this Cloud Function.
+	// Labels associated with this Cloud Function. The labels identifying the
+	// deployment are added at deploy time, see withWaypointLabels.
 	Labels map[string]string `hcl:"labels,optional"`
 
 	// Network: The VPC Network that this cloud function can connect to. It
@@ -113,6 +147,94 @@ type DeployConfig struct {
 	//   "ALL_TRAFFIC" - Force the use of VPC Access Connector for all
 	// egress traffic from the function.
 	VpcConnectorEgressSettings string `hcl:"vpc_connector_egress_settings,optional"`
+
+	// PlanOnly, if set to true, compares the configuration with the deployed
+	// function and outputs the changes without deploying anything.
+	PlanOnly bool `hcl:"plan_only,optional"`
+
+	// ServiceAccountEmail is the email of the service account the function
+	// runs as. Defaults to the App Engine default service account when the
+	// function is created, removing it afterwards keeps the current account.
+	ServiceAccountEmail string `hcl:"service_account_email,optional"`
+
+	// BuildServiceAccount is the service account used by Cloud Build to build
+	// the function, either its email or its resource name in the format
+	// `projects/{project}/serviceAccounts/{email}`. Like ServiceAccountEmail,
+	// removing it keeps the current account.
+	BuildServiceAccount string `hcl:"build_service_account,optional"`
+
+	// AllowDestroy, if set to true, deletes the function when the deployment
+	// is destroyed. Functions labeled with deletion-protection = "true" are
+	// never deleted. With Staged, only the staging function of the deployment
+	// is deleted, the function it was promoted to is left running.
+	AllowDestroy bool `hcl:"allow_destroy,optional"`
+
+	// Staged, if set to true, deploys each deployment to its own staging
+	// function, named after the function and the deployment. The staging
+	// function is promoted to the function when the deployment is released.
+	// Cannot be used with EventTrigger.
+	Staged bool `hcl:"staged,optional"`
+
+	// SmokeTest, if set, sends a request to HTTP functions once they are
+	// deployed and fails the deployment if the response is not the
+	// expected one.
+	SmokeTest *smokeTest `hcl:"smoke_test,block"`
+
+	// SampleEvent, if set, invokes event functions with a sample event once
+	// they are deployed and fails the deployment if the execution fails.
+	// Only supported by 1st gen functions.
+	SampleEvent *sampleEvent `hcl:"sample_event,block"`
+
+	// OperationTimeout is the maximum time to wait for the function to be
+	// built and deployed, or deleted, e.g. "10m". Defaults to 20 minutes.
+	OperationTimeout string `hcl:"operation_timeout,optional"`
+}
+
+// waitOptions returns the options used to wait for the operations of the
+// function, reporting progress to st.
+func (d DeployConfig) waitOptions(st terminal.Status, message string) cloudfunctionsutil.WaitOptions {
+	// The timeout is validated by ConfigSet.
+	timeout, _ := time.ParseDuration(d.OperationTimeout)
+
+	return cloudfunctionsutil.WaitOptions{Timeout: timeout, Status: st, Message: message}
+}
+
+// waypointEnv lists the environment variables provided by Waypoint which are
+// set on functions. The others, such as WAYPOINT_CEB_INVITE_TOKEN, are only
+// used by the Waypoint entrypoint, which Cloud Functions don't run, and
+// would be readable by anyone allowed to get the function.
+var waypointEnv = map[string]bool{
+	"WAYPOINT_DEPLOYMENT_ID":          true,
+	"WAYPOINT_SERVER_ADDR":            true,
+	"WAYPOINT_SERVER_DISABLE":         true,
+	"WAYPOINT_SERVER_TLS":             true,
+	"WAYPOINT_SERVER_TLS_SKIP_VERIFY": true,
+}
+
+// withWaypointEnv returns a copy of the configuration where the environment
+// variables provided by Waypoint listed in waypointEnv, such as
+// WAYPOINT_DEPLOYMENT_ID, are added to EnvironmentVariables. Variables set in
+// environment_variables, its file or secret_environment_variables take
+// precedence over Waypoint's.
+func (d DeployConfig) withWaypointEnv(dconfig *component.DeploymentConfig) DeployConfig {
+	if dconfig == nil {
+		return d
+	}
+
+	env := make(map[string]string, len(d.EnvironmentVariables))
+	for k, v := range dconfig.Env() {
+		if _, ok := d.SecretEnvironmentVariables[k]; !ok && waypointEnv[k] {
+			env[k] = v
+		}
+	}
+
+	for k, v := range d.EnvironmentVariables {
+		env[k] = v
+	}
+
+	d.EnvironmentVariables = env
+
+	return d
 }
 
 func (d DeployConfig) toCF() *cloudfunctions.CloudFunction {
@@ -129,7 +251,10 @@ func (d DeployConfig) toCF() *cloudfunctions.CloudFunction {
 		MaxInstances:               d.MaxInstances,
 		Network:                    d.Network,
 		Runtime:                    d.Runtime,
-		ServiceAccountEmail:        "",
+		SecretEnvironmentVariables: d.secretEnvVarsToCF(),
+		SecretVolumes:              d.secretVolumesToCF(),
+		ServiceAccountEmail:        d.ServiceAccountEmail,
+		BuildServiceAccount:        d.BuildServiceAccount,
 		SourceArchiveUrl:           "",
 		SourceRepository:           nil,
 		Timeout:                    d.Timeout,
@@ -165,7 +290,9 @@ type eventTrigger struct {
 	// Additionally, some services may support short names when creating an `EventTrigger`.
 	// These will always be  returned in the normalized "long" format. See each *service's*
 	// documentation for supported formats.
-	Resource string `hcl:"resource"`
+	// Required for 1st gen functions. For 2nd gen functions, it is only used
+	// as the topic of Pub/Sub triggers, use EventFilters for other events.
+	Resource string `hcl:"resource,optional"`
 
 	// FailurePolicy: Specifies policy for failed executions.
 	FailurePolicy failurePolicy `hcl:"failure_policy,block"`
@@ -175,6 +302,11 @@ type eventTrigger struct {
 	// used. For example, `storage.googleapis.com` is the default for all
 	// event types in the `google.storage` namespace.
 	Service string `hcl:"service,optional"`
+
+	// EventFilters are the Eventarc filters of the events to observe, e.g.
+	// bucket = "my-bucket" for Cloud Storage events. Only used by 2nd gen
+	// functions.
+	EventFilters map[string]string `hcl:"event_filters,optional"`
 }
 
 func (t *eventTrigger) toCF() *cloudfunctions.EventTrigger {
@@ -236,13 +368,115 @@ func (p *Platform) ConfigSet(config interface{}) error {
 	}
 
 	// validate the config
+	if _, err := c.parseName(); err != nil {
+		return err
+	}
+
+	workspaces := map[string]bool{}
+	for _, w := range c.Workspaces {
+		if workspaces[w.Workspace] {
+			return fmt.Errorf("workspace %q is configured more than once", w.Workspace)
+		}
+		workspaces[w.Workspace] = true
+
+		if _, err := (DeployConfig{Name: w.Name}).parseName(); err != nil {
+			return fmt.Errorf("workspace %q: %w", w.Workspace, err)
+		}
+	}
+
 	if c.TriggerHTTP && c.EventTrigger != nil {
 		return fmt.Errorf("trigger_http and event_type cannot be used together")
 	}
 
+	// The staging function of an event function would subscribe to the same
+	// events as the function, and process them a second time.
+	if c.Staged && c.EventTrigger != nil {
+		return fmt.Errorf("staged cannot be used with event_trigger")
+	}
+
+	if c.SmokeTest != nil {
+		if !c.TriggerHTTP {
+			return fmt.Errorf("smoke_test requires trigger_http")
+		}
+
+		if err := c.SmokeTest.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.SampleEvent != nil {
+		if c.EventTrigger == nil {
+			return fmt.Errorf("sample_event requires event_trigger")
+		}
+
+		if err := c.SampleEvent.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateSecrets(); err != nil {
+		return err
+	}
+
+	if err := c.validateLabels(); err != nil {
+		return err
+	}
+
+	if c.ServiceAccountEmail != "" && !emailRegexp.MatchString(c.ServiceAccountEmail) {
+		return fmt.Errorf("invalid service_account_email %q", c.ServiceAccountEmail)
+	}
+
+	if c.BuildServiceAccount != "" {
+		name, err := serviceAccountResourceName(c.BuildServiceAccount)
+		if err != nil {
+			return fmt.Errorf("invalid build_service_account: %w", err)
+		}
+
+		c.BuildServiceAccount = name
+	}
+
+	if c.OperationTimeout != "" {
+		if _, err := time.ParseDuration(c.OperationTimeout); err != nil {
+			return fmt.Errorf("invalid operation_timeout %q: %w", c.OperationTimeout, err)
+		}
+	}
+
 	return nil
 }
 
+// emailRegexp loosely matches email addresses, to catch obvious mistakes in
+// service account emails.
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// serviceAccountResourceName returns the resource name of a service account
+// given either its email or its resource name. The project is read from the
+// email of user-managed service accounts, other accounts must be given by
+// resource name.
+func serviceAccountResourceName(account string) (string, error) {
+	const suffix = ".iam.gserviceaccount.com"
+
+	if strings.HasPrefix(account, "projects/") {
+		parts := strings.Split(account, "/")
+		if len(parts) != 4 || parts[2] != "serviceAccounts" || !emailRegexp.MatchString(parts[3]) {
+			return "", fmt.Errorf("%q must be in the format projects/{project}/serviceAccounts/{email}", account)
+		}
+
+		return account, nil
+	}
+
+	if !emailRegexp.MatchString(account) || !strings.HasSuffix(account, suffix) {
+		return "", fmt.Errorf(
+			"%q must be the email of a user-managed service account or in the format "+
+				"projects/{project}/serviceAccounts/{email}",
+			account,
+		)
+	}
+
+	project := strings.TrimSuffix(account[strings.Index(account, "@")+1:], suffix)
+
+	return fmt.Sprintf("projects/%s/serviceAccounts/%s", project, account), nil
+}
+
 // DeployFunc implements component.Builder.
 func (p *Platform) DeployFunc() interface{} {
 	// return a function which will be called by Waypoint
@@ -277,7 +511,11 @@ func (p *Platform) DeployFunc() interface{} {
 // returns an error to the user.
 func (p *Platform) deploy(
 	ctx context.Context,
+	log hclog.Logger,
 	source *component.Source,
+	job *component.JobInfo,
+	labels *component.LabelSet,
+	dconfig *component.DeploymentConfig,
 	ui terminal.UI,
 	artifact *registry.Artifact,
 ) (*Deployment, error) {
@@ -286,16 +524,118 @@ func (p *Platform) deploy(
 
 	project := artifact.Project
 	location := artifact.Location
-	sourceApp := source.App
-	functionName := fmt.Sprintf("projects/%s/locations/%s/functions/%s", project, location, sourceApp)
+
+	config := p.config.forWorkspace(job)
+
+	functionID, err := config.functionID(source, job, artifact.Generation)
+	if err != nil {
+		st.Step(terminal.StatusError, "Invalid function name")
+		return nil, err
+	}
+
+	functionName := fmt.Sprintf("projects/%s/locations/%s/functions/%s", project, location, functionID)
+
+	// Staged deployments deploy a staging function which is promoted to the
+	// function on release.
+	var productionName string
+	if config.Staged {
+		productionName = functionName
+		functionName = fmt.Sprintf(
+			"projects/%s/locations/%s/functions/%s",
+			project, location, stagingFunctionID(functionID, dconfig),
+		)
+	}
 
 	st.Update("Deploying Google Cloud Function '" + functionName + "'")
 
-	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	config, warnings, err := config.withEnvFiles(source.Path)
 	if err != nil {
+		st.Step(terminal.StatusError, "Error loading the environment variables files")
 		return nil, err
 	}
 
+	for _, warning := range warnings {
+		st.Step(terminal.StatusWarn, warning)
+	}
+
+	config = config.withWaypointEnv(dconfig).withWaypointLabels(labels, source, job, dconfig)
+	if productionName != "" {
+		config.Labels[stagingOfLabel] = sanitizeLabelValue(functionID)
+	}
+
+	var (
+		deployment *Deployment
+		previous   *cloudfunctions.CloudFunction
+	)
+	if artifact.Generation == 2 {
+		deployment, err = p.deployV2(ctx, log, st, config, functionName, artifact)
+	} else {
+		deployment, previous, err = p.deployV1(ctx, log, ui, st, config, functionName, artifact)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deployment.DeploymentId = config.Labels[deploymentIDLabel]
+	deployment.ProductionName = productionName
+
+	if err := config.verify(ctx, log, st, deployment); err != nil {
+		// 1st gen functions only have a single version, the previous one is
+		// restored so that the function keeps working.
+		if previous != nil {
+			opts := config.waitOptions(st, "Rolling back Function '"+functionName+"'")
+			if rerr := rollback(ctx, st, deployment, previous, opts); rerr != nil {
+				return nil, fmt.Errorf("%w, the rollback to the previous version failed: %v", err, rerr)
+			}
+		}
+
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// verify runs the checks of the configuration against the deployed
+// function.
+func (d DeployConfig) verify(ctx context.Context, log hclog.Logger, st terminal.Status, deployment *Deployment) error {
+	if t := d.SmokeTest; t != nil {
+		if err := t.run(ctx, log, st, deployment.Url); err != nil {
+			return err
+		}
+	}
+
+	if e := d.SampleEvent; e != nil {
+		if err := e.invoke(ctx, log, st, deployment.Name, d.EventTrigger); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deployV1 deploys a 1st gen function using the Cloud Functions v1 API.
+func (p *Platform) deployV1(
+	ctx context.Context,
+	log hclog.Logger,
+	ui terminal.UI,
+	st terminal.Status,
+	config DeployConfig,
+	functionName string,
+	artifact *registry.Artifact,
+) (*Deployment, *cloudfunctions.CloudFunction, error) {
+	project := artifact.Project
+	location := artifact.Location
+
+	if t := config.EventTrigger; t != nil && t.Resource == "" {
+		st.Step(terminal.StatusError, "Invalid configuration")
+		return nil, nil, errors.New("event_trigger resource is required for 1st gen functions")
+	}
+
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	st.Update("Checking if function already exists " + functionName + "'")
 
 	// We need to determine if we're creating or updating a function. To
@@ -311,52 +651,65 @@ func (p *Platform) deploy(
 			create = true
 		} else {
 			st.Step(terminal.StatusError, "Error fetching function")
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
+	desired := config.toCF()
+	desired.Name = functionName
+	if strings.HasPrefix(artifact.Source, "gs://") {
+		desired.SourceArchiveUrl = artifact.Source
+	} else {
+		desired.SourceUploadUrl = artifact.Source
+	}
+
+	if config.PlanOnly {
+		st.Step(terminal.StatusOK, "Plan only, comparing the configuration with the deployed function")
+		st.Close()
+
+		renderPlan(ui, functionName, diffFunction(cf, desired))
+
+		return nil, nil, errPlanOnly
+	}
+
 	var op *cloudfunctions.Operation
 
 	if create {
 		st.Step(terminal.StatusOK, "Google Cloud Function does not exist, creating function")
 
-		cf := p.config.toCF()
-		cf.Name = functionName
-		cf.SourceUploadUrl = artifact.Source
-
-		op, err = createFunction(ctx, cloudfunctionsService, project, location, cf)
+		op, err = createFunction(ctx, cloudfunctionsService, project, location, desired)
 	} else {
 		st.Step(terminal.StatusOK, "Google Cloud Function already exists, updating function")
 
-		// TODO: handle any other updated fields passed as parameters to waypoint.
-		cf.SourceUploadUrl = artifact.Source
-
-		op, err = patchFunc(ctx, cloudfunctionsService, cf)
+		op, err = patchFunc(ctx, cloudfunctionsService, desired, updateMask(cf, desired))
 	}
 
 	if err != nil {
 		st.Step(terminal.StatusError, "Error deploying function")
-		return nil, err
+		return nil, nil, err
 	}
 
-	st.Update("Building Function '" + op.Name + "'")
+	buildLogs := buildLogStreamer{log: log, st: st}
+
+	opts := config.waitOptions(st, "Building Function '"+op.Name+"'")
+	opts.Progress = buildLogs.progress
 
-	op, err = cloudfunctionsutil.WaitForOperation(ctx, cloudfunctionsService, op)
+	op, err = cloudfunctionsutil.WaitForOperation(ctx, cloudfunctionsService, op, opts)
 	if err != nil {
 		st.Step(terminal.StatusError, "Error fetching build status")
-		return nil, err
+		return nil, nil, err
 	}
 
 	if op.Error != nil {
 		st.Step(terminal.StatusError, "Build error")
-		return nil, errors.New(op.Error.Message)
+		return nil, nil, buildLogs.buildError(op.Error.Message)
 	}
 
 	var cfresp cloudfunctions.CloudFunction
 	err = json.Unmarshal(op.Response, &cfresp)
 	if err != nil {
 		st.Step(terminal.StatusError, "Error reading the response data but function successfully deployed")
-		return nil, err
+		return nil, nil, err
 	}
 
 	versionID := cfresp.VersionId
@@ -368,7 +721,15 @@ func (p *Platform) deploy(
 		url = t.Url
 	}
 
-	return &Deployment{Name: cfresp.Name, Version: versionID, Url: url}, nil
+	deployment := Deployment{Name: cfresp.Name, Version: versionID, Url: url, Generation: 1}
+	if create {
+		return &deployment, nil, nil
+	}
+
+	deployment.PreviousVersion = cf.VersionId
+	deployment.PreviousSource = functionSource(cf)
+
+	return &deployment, cf, nil
 }
 
 func createFunction(
@@ -392,15 +753,18 @@ func createFunction(
 	return op, nil
 }
 
+// patchFunc updates the fields of the function listed in mask with the values
+// in req.
 func patchFunc(
 	ctx context.Context,
 	service *cloudfunctions.Service,
 	req *cloudfunctions.CloudFunction,
+	mask []string,
 ) (*cloudfunctions.Operation, error) {
 	patchCall := service.Projects.Locations.Functions.
 		Patch(req.Name, req).
 		Context(ctx).
-		UpdateMask("sourceUploadUrl")
+		UpdateMask(strings.Join(mask, ","))
 
 	op, err := patchCall.Do()
 	if err != nil {
@@ -0,0 +1,37 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/component"
+)
+
+func TestWithWaypointEnv(t *testing.T) {
+	dconfig := &component.DeploymentConfig{
+		Id:                    "01EXAMPLE",
+		ServerAddr:            "waypoint.example.com:9701",
+		ServerTls:             true,
+		EntrypointInviteToken: "secret-token",
+	}
+
+	config := DeployConfig{
+		EnvironmentVariables:       map[string]string{"WAYPOINT_SERVER_TLS": "0", "A": "1"},
+		SecretEnvironmentVariables: map[string]string{"WAYPOINT_SERVER_ADDR": "addr"},
+	}
+
+	got := config.withWaypointEnv(dconfig).EnvironmentVariables
+
+	want := map[string]string{
+		"A":                      "1",
+		"WAYPOINT_DEPLOYMENT_ID": "01EXAMPLE",
+		"WAYPOINT_SERVER_TLS":    "0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EnvironmentVariables = %v, want %v", got, want)
+	}
+
+	if _, ok := config.EnvironmentVariables["WAYPOINT_DEPLOYMENT_ID"]; ok {
+		t.Error("the configuration was modified")
+	}
+}
@@ -0,0 +1,234 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/registry"
+)
+
+// pubsubEventTypeV2 is the Eventarc event type of Pub/Sub topics, the resource
+// of such triggers is used as the topic.
+const pubsubEventTypeV2 = "google.cloud.pubsub.topic.v1.messagePublished"
+
+// updateMaskV2 lists the fields of a 2nd gen function managed by DeployConfig.
+// They are always updated as a whole, so that the configuration stays the
+// source of truth.
+var updateMaskV2 = []string{"buildConfig", "serviceConfig", "eventTrigger", "labels", "description"}
+
+// validateV2 checks the configuration fields that aren't supported by
+// 2nd gen functions.
+func (d DeployConfig) validateV2() error {
+	if d.Network != "" {
+		return errors.New("network is not supported by 2nd gen functions, use vpc_connector instead")
+	}
+
+	if d.PlanOnly {
+		return errors.New("plan_only is not supported by 2nd gen functions")
+	}
+
+	if d.SampleEvent != nil {
+		return errors.New("sample_event is not supported by 2nd gen functions")
+	}
+
+	if d.Timeout != "" {
+		if _, err := time.ParseDuration(d.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", d.Timeout, err)
+		}
+	}
+
+	return nil
+}
+
+// toCFv2 returns the function in the format expected by the v2 API.
+func (d DeployConfig) toCFv2(source *registry.StorageSource) *cloudfunctionsv2.Function {
+	fn := &cloudfunctionsv2.Function{
+		Description: d.Description,
+		Labels:      d.Labels,
+		BuildConfig: &cloudfunctionsv2.BuildConfig{
+			EntryPoint:           d.EntryPoint,
+			EnvironmentVariables: d.BuildEnvironmentVariables,
+			Runtime:              d.Runtime,
+			ServiceAccount:       d.BuildServiceAccount,
+		},
+		ServiceConfig: &cloudfunctionsv2.ServiceConfig{
+			EnvironmentVariables:       d.EnvironmentVariables,
+			IngressSettings:            d.IngressSettings,
+			MaxInstanceCount:           d.MaxInstances,
+			SecretEnvironmentVariables: d.secretEnvVarsToCFv2(),
+			SecretVolumes:              d.secretVolumesToCFv2(),
+			ServiceAccountEmail:        d.ServiceAccountEmail,
+			VpcConnector:               d.VpcConnector,
+			VpcConnectorEgressSettings: d.VpcConnectorEgressSettings,
+		},
+		EventTrigger: d.EventTrigger.toCFv2(),
+	}
+
+	if source != nil {
+		fn.BuildConfig.Source = &cloudfunctionsv2.Source{
+			StorageSource: &cloudfunctionsv2.StorageSource{
+				Bucket:     source.Bucket,
+				Object:     source.Object,
+				Generation: source.Generation,
+			},
+		}
+	}
+
+	if d.AvailableMemoryMB > 0 {
+		fn.ServiceConfig.AvailableMemory = fmt.Sprintf("%dM", d.AvailableMemoryMB)
+	}
+
+	// The timeout is validated by validateV2.
+	if timeout, err := time.ParseDuration(d.Timeout); err == nil {
+		fn.ServiceConfig.TimeoutSeconds = int64(math.Ceil(timeout.Seconds()))
+	}
+
+	return fn
+}
+
+func (t *eventTrigger) toCFv2() *cloudfunctionsv2.EventTrigger {
+	if t == nil {
+		return nil
+	}
+
+	trigger := &cloudfunctionsv2.EventTrigger{
+		EventType:   t.EventType,
+		RetryPolicy: "RETRY_POLICY_DO_NOT_RETRY",
+	}
+
+	if t.FailurePolicy.Retry {
+		trigger.RetryPolicy = "RETRY_POLICY_RETRY"
+	}
+
+	if t.EventType == pubsubEventTypeV2 {
+		trigger.PubsubTopic = t.Resource
+	}
+
+	for attribute, value := range t.EventFilters {
+		trigger.EventFilters = append(trigger.EventFilters, &cloudfunctionsv2.EventFilter{
+			Attribute: attribute,
+			Value:     value,
+		})
+	}
+
+	return trigger
+}
+
+// deployV2 deploys a 2nd gen function using the Cloud Functions v2 API.
+func (p *Platform) deployV2(
+	ctx context.Context,
+	log hclog.Logger,
+	st terminal.Status,
+	config DeployConfig,
+	functionName string,
+	artifact *registry.Artifact,
+) (*Deployment, error) {
+	if err := config.validateV2(); err != nil {
+		st.Step(terminal.StatusError, "Invalid configuration for a 2nd gen function")
+		return nil, err
+	}
+
+	if artifact.StorageSource == nil {
+		st.Step(terminal.StatusError, "Invalid artifact")
+		return nil, errors.New("the artifact wasn't uploaded for a 2nd gen function, set generation = 2 in the registry")
+	}
+
+	cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	st.Update("Checking if function already exists '" + functionName + "'")
+
+	create := false
+
+	var gerr *googleapi.Error
+
+	_, err = cloudfunctionsService.Projects.Locations.Functions.Get(functionName).Context(ctx).Do()
+	if err != nil {
+		if errors.As(err, &gerr) && gerr.Code == 404 {
+			create = true
+		} else {
+			st.Step(terminal.StatusError, "Error fetching function")
+			return nil, err
+		}
+	}
+
+	fn := config.toCFv2(artifact.StorageSource)
+	fn.Name = functionName
+
+	var op *cloudfunctionsv2.Operation
+
+	if create {
+		st.Step(terminal.StatusOK, "Google Cloud Function does not exist, creating 2nd gen function")
+
+		parent := fmt.Sprintf("projects/%s/locations/%s", artifact.Project, artifact.Location)
+		functionID := functionName[strings.LastIndex(functionName, "/")+1:]
+
+		op, err = cloudfunctionsService.Projects.Locations.Functions.
+			Create(parent, fn).
+			FunctionId(functionID).
+			Context(ctx).
+			Do()
+	} else {
+		st.Step(terminal.StatusOK, "Google Cloud Function already exists, updating 2nd gen function")
+
+		op, err = cloudfunctionsService.Projects.Locations.Functions.
+			Patch(functionName, fn).
+			UpdateMask(strings.Join(updateMaskV2, ",")).
+			Context(ctx).
+			Do()
+	}
+
+	if err != nil {
+		st.Step(terminal.StatusError, "Error deploying function")
+		return nil, err
+	}
+
+	buildLogs := buildLogStreamer{log: log, st: st}
+
+	opts := config.waitOptions(st, "Building Function '"+op.Name+"'")
+	opts.Progress = buildLogs.progress
+
+	op, err = cloudfunctionsutil.WaitForOperationV2(ctx, cloudfunctionsService, op, opts)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching build status")
+		return nil, err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Build error")
+		return nil, buildLogs.buildError(op.Error.Message)
+	}
+
+	var fnresp cloudfunctionsv2.Function
+	err = json.Unmarshal(op.Response, &fnresp)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error reading the response data but function successfully deployed")
+		return nil, err
+	}
+
+	deployment := Deployment{Name: fnresp.Name, Generation: 2}
+	if c := fnresp.ServiceConfig; c != nil {
+		deployment.Service = c.Service
+		deployment.Revision = c.Revision
+		if fnresp.EventTrigger == nil {
+			deployment.Url = c.Uri
+		}
+
+		st.Step(terminal.StatusOK, fmt.Sprintf("Google Cloud Function successfully deployed '%s'", c.Revision))
+	}
+
+	return &deployment, nil
+}
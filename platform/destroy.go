@@ -2,10 +2,21 @@ package platform
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/cloudfunctions/v1"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
 )
 
+// deletionProtectionLabel is the label which, when set to "true" on a
+// function, prevents it from being deleted by destroy.
+const deletionProtectionLabel = "deletion-protection"
+
 // Implement the Destroyer interface
 func (p *Platform) DestroyFunc() interface{} {
 	return p.destroy
@@ -39,5 +50,214 @@ func (p *Platform) DestroyFunc() interface{} {
 // If an error is returned, Waypoint stops the execution flow and
 // returns an error to the user.
 func (p *Platform) destroy(ctx context.Context, ui terminal.UI, deployment *Deployment) error {
+	st := ui.Status()
+	defer st.Close()
+
+	if !p.config.AllowDestroy {
+		st.Step(
+			terminal.StatusWarn,
+			"Google Cloud Function '"+deployment.Name+"' not deleted, set allow_destroy = true to delete it",
+		)
+		return nil
+	}
+
+	st.Update("Deleting Google Cloud Function '" + deployment.Name + "'")
+
+	opts := p.config.waitOptions(st, "Deleting Google Cloud Function '"+deployment.Name+"'")
+
+	var (
+		deleted bool
+		err     error
+	)
+	if deployment.Generation == 2 {
+		deleted, err = deleteFunctionV2(ctx, st, deployment, opts)
+	} else {
+		deleted, err = deleteFunction(ctx, st, deployment, opts)
+	}
+	if err != nil || !deleted {
+		return err
+	}
+
+	st.Step(terminal.StatusOK, "Google Cloud Function '"+deployment.Name+"' successfully deleted")
+
 	return nil
 }
+
+// deleteFunction deletes the 1st gen function of the deployment unless it is
+// protected, not managed by Waypoint or updated by another deployment. It
+// reports whether the function doesn't exist anymore.
+func deleteFunction(
+	ctx context.Context,
+	st terminal.Status,
+	deployment *Deployment,
+	opts cloudfunctionsutil.WaitOptions,
+) (bool, error) {
+	name := deployment.Name
+
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	cf, err := cloudfunctionsService.Projects.Locations.Functions.Get(name).Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == 404 {
+			return true, nil
+		}
+
+		st.Step(terminal.StatusError, "Error fetching function")
+		return false, err
+	}
+
+	// Every deployment updates the same function, only the one which last
+	// updated it may delete it.
+	owned, err := checkManagedByWaypoint(
+		st, name, deployment.DeploymentId, cf.Labels,
+		cf.VersionId == deployment.Version,
+	)
+	if !owned {
+		return false, err
+	}
+
+	if err := checkDeletionProtection(st, name, cf.Labels); err != nil {
+		return false, err
+	}
+
+	op, err := cloudfunctionsService.Projects.Locations.Functions.Delete(name).Context(ctx).Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error deleting function")
+		return false, err
+	}
+
+	op, err = cloudfunctionsutil.WaitForOperation(ctx, cloudfunctionsService, op, opts)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching deletion status")
+		return false, err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Error deleting function")
+		return false, errors.New(op.Error.Message)
+	}
+
+	return true, nil
+}
+
+// deleteFunctionV2 is the equivalent of deleteFunction for 2nd gen functions,
+// whose version is the Cloud Run revision serving them.
+func deleteFunctionV2(
+	ctx context.Context,
+	st terminal.Status,
+	deployment *Deployment,
+	opts cloudfunctionsutil.WaitOptions,
+) (bool, error) {
+	name := deployment.Name
+
+	cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	fn, err := cloudfunctionsService.Projects.Locations.Functions.Get(name).Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == 404 {
+			return true, nil
+		}
+
+		st.Step(terminal.StatusError, "Error fetching function")
+		return false, err
+	}
+
+	var revision string
+	if fn.ServiceConfig != nil {
+		revision = fn.ServiceConfig.Revision
+	}
+
+	owned, err := checkManagedByWaypoint(
+		st, name, deployment.DeploymentId, fn.Labels,
+		deployment.Revision != "" && revision == deployment.Revision,
+	)
+	if !owned {
+		return false, err
+	}
+
+	if err := checkDeletionProtection(st, name, fn.Labels); err != nil {
+		return false, err
+	}
+
+	op, err := cloudfunctionsService.Projects.Locations.Functions.Delete(name).Context(ctx).Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error deleting function")
+		return false, err
+	}
+
+	op, err = cloudfunctionsutil.WaitForOperationV2(ctx, cloudfunctionsService, op, opts)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching deletion status")
+		return false, err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Error deleting function")
+		return false, errors.New(op.Error.Message)
+	}
+
+	return true, nil
+}
+
+// IsCurrentDeployment reports whether the function of the deployment was not
+// updated by another deployment since, following the same rules as destroy.
+// It returns a googleapi.Error with the code 404 if the function doesn't
+// exist.
+func IsCurrentDeployment(ctx context.Context, deployment *Deployment) (bool, error) {
+	if deployment.Generation == 2 {
+		cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		fn, err := cloudfunctionsService.Projects.Locations.Functions.Get(deployment.Name).Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+
+		var revision string
+		if fn.ServiceConfig != nil {
+			revision = fn.ServiceConfig.Revision
+		}
+
+		return deployedBy(
+			deployment.DeploymentId, fn.Labels,
+			deployment.Revision != "" && revision == deployment.Revision,
+		), nil
+	}
+
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	cf, err := cloudfunctionsService.Projects.Locations.Functions.Get(deployment.Name).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+
+	return deployedBy(deployment.DeploymentId, cf.Labels, cf.VersionId == deployment.Version), nil
+}
+
+// checkDeletionProtection returns an error if the labels of the function
+// protect it from deletion.
+func checkDeletionProtection(st terminal.Status, name string, labels map[string]string) error {
+	if labels[deletionProtectionLabel] != "true" {
+		return nil
+	}
+
+	st.Step(terminal.StatusError, "Google Cloud Function '"+name+"' is protected from deletion")
+
+	return fmt.Errorf(
+		"function %s has the label %s=true, remove it to allow the function to be deleted",
+		name, deletionProtectionLabel,
+	)
+}
@@ -49,9 +49,53 @@ app "helloworld" {
 }
 `)
 
+	_ = doc.SetField(
+		"name",
+		`Name of the function, a Go template executed with the App and Workspace, e.g. "{{.App}}-{{.Workspace}}".
+Characters not allowed in function names are replaced with dashes in the values. The name must start with a
+letter, contain only letters, digits, dashes and underscores and be at most 63 characters long, 2nd gen functions
+are limited to lowercase letters, digits and dashes. Defaults to "{{.App}}".`,
+	)
+
+	_ = doc.SetField(
+		"workspace",
+		`Overrides the name of the function for a Waypoint workspace, e.g. 'workspace "staging" { name = "..." }'.`,
+	)
+
+	_ = doc.SetField(
+		"staged",
+		`If set to true, each deployment creates its own staging function, named after the function and
+the deployment, e.g. "helloworld-stg-01ejt7fxzq", and labeled waypoint-staging-of. The release promotes
+the staging function by updating the function to the same source and configuration, then deletes the
+staging functions of the previous deployments. Cannot be used with event_trigger.`,
+	)
+
+	_ = doc.SetField(
+		"smoke_test",
+		`Sends a request to the URL of an HTTP function once it is deployed, and fails the deployment if the
+response is not the expected one. The block takes an optional 'method' (defaults to GET), 'path', 'body',
+'headers', 'expected_status' (defaults to 200), 'body_regex' the body of the response must match, and
+'timeout' during which the request is retried (defaults to "1m"). An identity token is sent when the
+credentials are those of a service account, so that functions which are not public can be tested.
+When the test fails, a 1st gen function updated by the deployment is rolled back to the source and
+configuration of its previous version. The source of a version deployed without a bucket is downloaded
+from Cloud Functions, the rollback fails if it is not available anymore.`,
+	)
+
+	_ = doc.SetField(
+		"sample_event",
+		`Invokes a function with an event_trigger once it is deployed, using the Cloud Functions Call API, and
+fails the deployment if the execution fails. The optional 'data' sets the event as JSON, it defaults to a
+generated Pub/Sub message or Cloud Storage object depending on the event type. When the execution fails,
+a function updated by the deployment is rolled back to the source and configuration of its previous version.
+Only supported by 1st gen functions.`,
+	)
+
 	_ = doc.SetField(
 		"environment_variables",
-		"Environment Variables that shall be available during function execution.",
+		`Environment Variables that shall be available during function execution. The variables provided
+by Waypoint, WAYPOINT_DEPLOYMENT_ID and the WAYPOINT_SERVER_* variables, are added to them unless
+they are set here, in environment_variables_file or in secret_environment_variables.`,
 	)
 
 	_ = doc.SetField(
@@ -59,6 +103,34 @@ app "helloworld" {
 		"Build Environment Variables that shall be available during build time.",
 	)
 
+	_ = doc.SetField(
+		"environment_variables_file",
+		`Path, relative to the app, of a file defining environment variables: either a dotenv file
+or, if its extension is .yaml or .yml, a YAML file in the format of 'gcloud functions deploy --env-vars-file'.
+Variables set in environment_variables take precedence over the ones of the file.`,
+	)
+
+	_ = doc.SetField(
+		"build_environment_variables_file",
+		`Path, relative to the app, of a dotenv or YAML file defining build environment variables.
+Variables set in build_environment_variables take precedence over the ones of the file.`,
+	)
+
+	_ = doc.SetField(
+		"secret_environment_variables",
+		`Environment variables set from Secret Manager secret versions, in the format '{secret}:{version}'
+or 'projects/{project}/secrets/{secret}/versions/{version}'. The version defaults to 'latest'.
+Only the references to the secrets are sent to Google Cloud Functions, the secret values are never
+read by the plugin nor shown in its output.`,
+	)
+
+	_ = doc.SetField(
+		"secret_volumes",
+		`Secret Manager secrets mounted as files. Each block takes a 'mount_path', the 'secret' as its ID
+or in the format 'projects/{project}/secrets/{secret}', and optional 'versions' mapping file paths
+relative to the mount path to secret versions.`,
+	)
+
 	_ = doc.SetField(
 		"max_instances",
 		`MaxInstances sets the maximum number of instances for the function.
@@ -131,7 +203,12 @@ Cannot be used with EventTrigger.`,
 Cannot be used with TriggerHTTP.`,
 	)
 
-	_ = doc.SetField("labels", `Labels associated with this Cloud Function.`)
+	_ = doc.SetField(
+		"labels",
+		`Labels associated with this Cloud Function. The Waypoint labels of the deployment are added to them,
+as well as the labels waypoint-app, waypoint-workspace, waypoint-deployment-id and managed-by = "waypoint",
+which are reserved.`,
+	)
 
 	_ = doc.SetField(
 		"network",
@@ -166,5 +243,42 @@ Possible values:
  - "ALL_TRAFFIC" - Force the use of VPC Access Connector for all egress traffic from the function.`,
 	)
 
+	_ = doc.SetField(
+		"plan_only",
+		`If set to true, compares the configuration with the deployed function and outputs
+the field by field changes without deploying anything. The values of environment variables are
+not displayed. The deploy then fails on purpose
+so that the release stage is not run.`,
+	)
+
+	_ = doc.SetField(
+		"service_account_email",
+		`Email of the service account the function runs as.
+Defaults to the App Engine default service account, which has broad permissions on the project.
+Removing it from the configuration doesn't reset a deployed function to the default account, the
+account previously set is kept: set it to the default account explicitly to switch back.`,
+	)
+
+	_ = doc.SetField(
+		"build_service_account",
+		`Service account used by Cloud Build to build the function. Either the email of a user-managed
+service account or its resource name in the format 'projects/{project}/serviceAccounts/{email}'.
+As with service_account_email, removing it keeps the account previously set on the function.`,
+	)
+
+	_ = doc.SetField(
+		"allow_destroy",
+		`If set to true, the function is deleted when the deployment is destroyed. Defaults to false,
+in which case destroying a deployment leaves the function running.
+Functions with the label 'deletion-protection' set to "true" are never deleted. With staged = true,
+only the staging function of the deployment is deleted, the function it was promoted to is left running.`,
+	)
+
+	_ = doc.SetField(
+		"operation_timeout",
+		`Maximum time to wait for the function to be built and deployed, or deleted, e.g. "10m".
+Defaults to 20 minutes.`,
+	)
+
 	return doc, nil
 }
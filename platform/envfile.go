@@ -0,0 +1,209 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// withEnvFiles returns a copy of the configuration where the environment
+// variables read from environment_variables_file and
+// build_environment_variables_file are merged into the inline ones. Relative
+// paths are resolved from appPath. Inline variables take precedence, the
+// returned warnings report the variables set in both places with different
+// values.
+func (d DeployConfig) withEnvFiles(appPath string) (DeployConfig, []string, error) {
+	var warnings []string
+
+	if d.EnvironmentVariablesFile != "" {
+		env, conflicts, err := mergeEnvFile(d.EnvironmentVariables, appPath, d.EnvironmentVariablesFile)
+		if err != nil {
+			return d, nil, err
+		}
+
+		d.EnvironmentVariables = env
+		for _, key := range conflicts {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is set in both environment_variables and %s, using environment_variables",
+				key, d.EnvironmentVariablesFile,
+			))
+		}
+	}
+
+	if d.BuildEnvironmentVariablesFile != "" {
+		env, conflicts, err := mergeEnvFile(d.BuildEnvironmentVariables, appPath, d.BuildEnvironmentVariablesFile)
+		if err != nil {
+			return d, nil, err
+		}
+
+		d.BuildEnvironmentVariables = env
+		for _, key := range conflicts {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is set in both build_environment_variables and %s, using build_environment_variables",
+				key, d.BuildEnvironmentVariablesFile,
+			))
+		}
+	}
+
+	// Variables from the files may collide with secrets.
+	if err := d.validateSecrets(); err != nil {
+		return d, nil, err
+	}
+
+	return d, warnings, nil
+}
+
+// mergeEnvFile reads the environment variables file and merges them with env.
+// It returns the merged variables and the sorted keys defined with different
+// values in both, for which env wins.
+func mergeEnvFile(env map[string]string, appPath, file string) (map[string]string, []string, error) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(appPath, file)
+	}
+
+	fileEnv, err := readEnvFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := make(map[string]string, len(env)+len(fileEnv))
+	for k, v := range fileEnv {
+		merged[k] = v
+	}
+
+	var conflicts []string
+
+	for k, v := range env {
+		if fv, ok := fileEnv[k]; ok && fv != v {
+			conflicts = append(conflicts, k)
+		}
+
+		merged[k] = v
+	}
+
+	sort.Strings(conflicts)
+
+	return merged, conflicts, nil
+}
+
+// readEnvFile reads environment variables from a YAML file, as expected by
+// `gcloud functions deploy --env-vars-file`, if its extension is .yaml or
+// .yml, or from a dotenv file otherwise.
+func readEnvFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var env map[string]string
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &env)
+	default:
+		env, err = parseDotEnv(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading environment variables from %s: %w", file, err)
+	}
+
+	return env, nil
+}
+
+// parseDotEnv parses the KEY=VALUE lines of a dotenv file. Empty lines and
+// lines starting with # are ignored, as well as a leading `export`. Values
+// can be single quoted, taken literally, or double quoted, in which case \n,
+// \" and \\ are unescaped. Values end at an inline # comment preceded by a
+// space, which may follow the closing quote of quoted values.
+func parseDotEnv(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+
+		key := strings.TrimSpace(line[:i])
+
+		value, err := parseDotEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		env[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// parseDotEnvValue parses the value of a dotenv line, with its quotes and
+// inline comment.
+func parseDotEnvValue(value string) (string, error) {
+	if value == "" || (value[0] != '\'' && value[0] != '"') {
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+
+		return value, nil
+	}
+
+	quote := value[0]
+
+	var (
+		b   strings.Builder
+		end = -1
+	)
+	for j := 1; j < len(value); j++ {
+		c := value[j]
+
+		if c == quote {
+			end = j
+			break
+		}
+
+		if quote == '"' && c == '\\' && j+1 < len(value) {
+			switch value[j+1] {
+			case 'n':
+				b.WriteByte('\n')
+				j++
+				continue
+			case '"', '\\':
+				b.WriteByte(value[j+1])
+				j++
+				continue
+			}
+		}
+
+		b.WriteByte(c)
+	}
+
+	if end < 0 {
+		return "", fmt.Errorf("missing closing %c", quote)
+	}
+
+	if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after the closing %c", rest, quote)
+	}
+
+	return b.String(), nil
+}
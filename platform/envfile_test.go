@@ -0,0 +1,138 @@
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "plain values",
+			input: "A=1\nB = two\nC=\n",
+			want:  map[string]string{"A": "1", "B": "two", "C": ""},
+		},
+		{
+			name:  "comments and empty lines",
+			input: "# comment\n\n  # indented comment\nA=1\n",
+			want:  map[string]string{"A": "1"},
+		},
+		{
+			name:  "export",
+			input: "export A=1\nexport  B=2\n",
+			want:  map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name:  "inline comment",
+			input: "A=1 # comment\nB=x#y\n",
+			want:  map[string]string{"A": "1", "B": "x#y"},
+		},
+		{
+			name:  "single quotes",
+			input: `A='a # b'` + "\n" + `B='\n'` + "\n",
+			want:  map[string]string{"A": "a # b", "B": `\n`},
+		},
+		{
+			name:  "double quotes",
+			input: `A="a\nb"` + "\n" + `B="say \"hi\""` + "\n" + `C="back\\slash"` + "\n" + `D="\t"` + "\n",
+			want:  map[string]string{"A": "a\nb", "B": `say "hi"`, "C": `back\slash`, "D": `\t`},
+		},
+		{
+			name:  "quoted values with an inline comment",
+			input: `A="x" # c` + "\n" + `B='y'   # c` + "\n" + `C="z # not a comment"` + "\n",
+			want:  map[string]string{"A": "x", "B": "y", "C": "z # not a comment"},
+		},
+		{
+			name:  "equal sign in the value",
+			input: "A=b=c\n",
+			want:  map[string]string{"A": "b=c"},
+		},
+		{
+			name:  "later values override earlier ones",
+			input: "A=1\nA=2\n",
+			want:  map[string]string{"A": "2"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseDotEnv(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseDotEnv() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseDotEnvErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "missing equal sign", input: "A=1\nB\n", err: "line 2: expected KEY=VALUE"},
+		{name: "missing key", input: "=1\n", err: "line 1: expected KEY=VALUE"},
+		{name: "unterminated quote", input: `A="x` + "\n", err: "line 1: missing closing \""},
+		{name: "text after the quotes", input: `A="x"y` + "\n", err: "line 1: unexpected \"y\" after the closing \""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseDotEnv(strings.NewReader(tc.input))
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("parseDotEnv() error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestReadEnvFileYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "env.yaml")
+	data := "STRING: text\nINT: 1\nBOOL: true\nFLOAT: 1.50\nOCTAL: '007'\nEMPTY: ~\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"STRING": "text",
+		"INT":    "1",
+		"BOOL":   "true",
+		"FLOAT":  "1.50",
+		"OCTAL":  "007",
+		"EMPTY":  "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readEnvFile() = %q, want %q", got, want)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("NESTED:\n  A: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readEnvFile(file); err == nil {
+		t.Error("readEnvFile() accepted a nested map")
+	}
+}
@@ -0,0 +1,155 @@
+package platform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/component"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+)
+
+// Labels set by the plugin on every function it deploys, on top of the labels
+// of the configuration.
+const (
+	appLabel          = "waypoint-app"
+	workspaceLabel    = "waypoint-workspace"
+	deploymentIDLabel = "waypoint-deployment-id"
+	managedByLabel    = "managed-by"
+
+	// stagingOfLabel is set on staging functions to the name of the function
+	// they are promoted to.
+	stagingOfLabel = "waypoint-staging-of"
+
+	managedByWaypoint = "waypoint"
+)
+
+// reservedLabels can't be set in the labels of the configuration.
+var reservedLabels = []string{appLabel, workspaceLabel, deploymentIDLabel, managedByLabel, stagingOfLabel}
+
+// maxLabelLength is the maximum length of the keys and values of labels.
+const maxLabelLength = 63
+
+// invalidLabelChars matches the characters not allowed in labels.
+var invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// validateLabels checks that the labels of the configuration don't override
+// the ones set by the plugin.
+func (d DeployConfig) validateLabels() error {
+	for _, key := range reservedLabels {
+		if _, ok := d.Labels[key]; ok {
+			return fmt.Errorf("the label %s is reserved, it is set by the plugin", key)
+		}
+	}
+
+	return nil
+}
+
+// withWaypointLabels returns a copy of the configuration where the labels of
+// the function include the Waypoint labels of the deployment and the labels
+// identifying the app, workspace and deployment, so that the functions
+// managed by Waypoint can be found e.g. in billing exports. The labels of the
+// configuration take precedence over the Waypoint labels.
+func (d DeployConfig) withWaypointLabels(
+	labels *component.LabelSet,
+	source *component.Source,
+	job *component.JobInfo,
+	dconfig *component.DeploymentConfig,
+) DeployConfig {
+	merged := map[string]string{}
+
+	if labels != nil {
+		for k, v := range labels.Labels {
+			merged[sanitizeLabelKey(k)] = sanitizeLabelValue(v)
+		}
+	}
+
+	for k, v := range d.Labels {
+		merged[k] = v
+	}
+
+	merged[managedByLabel] = managedByWaypoint
+
+	if source != nil && source.App != "" {
+		merged[appLabel] = sanitizeLabelValue(source.App)
+	}
+
+	if job != nil && job.Workspace != "" {
+		merged[workspaceLabel] = sanitizeLabelValue(job.Workspace)
+	}
+
+	if dconfig != nil && dconfig.Id != "" {
+		merged[deploymentIDLabel] = sanitizeLabelValue(dconfig.Id)
+	}
+
+	d.Labels = merged
+
+	return d
+}
+
+// sanitizeLabelKey turns key into a valid label key: lowercase letters,
+// digits, underscores and dashes, starting with a letter.
+func sanitizeLabelKey(key string) string {
+	key = sanitizeLabelValue(key)
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		key = truncateLabel("waypoint-" + key)
+	}
+
+	return key
+}
+
+// sanitizeLabelValue turns value into a valid label value: lowercase
+// letters, digits, underscores and dashes, at most 63 characters.
+func sanitizeLabelValue(value string) string {
+	return truncateLabel(invalidLabelChars.ReplaceAllString(strings.ToLower(value), "-"))
+}
+
+func truncateLabel(s string) string {
+	if len(s) > maxLabelLength {
+		return s[:maxLabelLength]
+	}
+
+	return s
+}
+
+// checkManagedByWaypoint returns an error if the function was not deployed by
+// the plugin, and reports whether it still belongs to the deployment, i.e. it
+// was not updated by another deployment since. The deployment is identified
+// by its ID when it was recorded, by whether it deployed the current version
+// of the function otherwise.
+func checkManagedByWaypoint(
+	st terminal.Status,
+	name, deploymentID string,
+	labels map[string]string,
+	currentVersion bool,
+) (bool, error) {
+	if labels[managedByLabel] != managedByWaypoint {
+		st.Step(terminal.StatusError, "Google Cloud Function '"+name+"' is not managed by Waypoint")
+
+		return false, fmt.Errorf(
+			"function %s doesn't have the label %s=%s, it was not deployed by Waypoint",
+			name, managedByLabel, managedByWaypoint,
+		)
+	}
+
+	owned := deployedBy(deploymentID, labels, currentVersion)
+	if !owned {
+		st.Step(
+			terminal.StatusWarn,
+			"Google Cloud Function '"+name+"' not deleted, it was updated by another deployment",
+		)
+	}
+
+	return owned, nil
+}
+
+// deployedBy reports whether the function with labels was last updated by the
+// deployment with ID deploymentID, or by whether the deployment deployed its
+// current version when the ID wasn't recorded.
+func deployedBy(deploymentID string, labels map[string]string, currentVersion bool) bool {
+	if deploymentID == "" {
+		return currentVersion
+	}
+
+	return labels[deploymentIDLabel] == deploymentID
+}
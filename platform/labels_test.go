@@ -0,0 +1,71 @@
+package platform
+
+import (
+	"testing"
+)
+
+// recordStatus is a terminal.Status recording the status of its steps.
+type recordStatus struct {
+	steps []string
+}
+
+func (s *recordStatus) Update(msg string)       {}
+func (s *recordStatus) Step(status, msg string) { s.steps = append(s.steps, status) }
+func (s *recordStatus) Close() error            { return nil }
+
+func TestCheckManagedByWaypoint(t *testing.T) {
+	managed := map[string]string{managedByLabel: managedByWaypoint, deploymentIDLabel: "01a"}
+
+	cases := []struct {
+		name           string
+		deploymentID   string
+		labels         map[string]string
+		currentVersion bool
+		want           bool
+		wantErr        bool
+	}{
+		{name: "same deployment", deploymentID: "01a", labels: managed, want: true},
+		{name: "other deployment", deploymentID: "01b", labels: managed, currentVersion: true},
+		{name: "no deployment ID, current version", labels: managed, currentVersion: true, want: true},
+		{name: "no deployment ID, other version", labels: managed},
+		{
+			name:   "no deployment ID, unlabeled function",
+			labels: map[string]string{managedByLabel: managedByWaypoint},
+		},
+		{name: "not managed", deploymentID: "01a", labels: map[string]string{deploymentIDLabel: "01a"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &recordStatus{}
+
+			got, err := checkManagedByWaypoint(st, "f", tc.deploymentID, tc.labels, tc.currentVersion)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("error = %v, want error %t", err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("owned = %t, want %t", got, tc.want)
+			}
+
+			if !got && len(st.steps) != 1 {
+				t.Errorf("got steps %v, want a warning or an error", st.steps)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabels(t *testing.T) {
+	if got := sanitizeLabelValue("My App.v2"); got != "my-app-v2" {
+		t.Errorf("sanitizeLabelValue() = %q", got)
+	}
+
+	if got := sanitizeLabelKey("1st"); got != "waypoint-1st" {
+		t.Errorf("sanitizeLabelKey() = %q", got)
+	}
+
+	long := sanitizeLabelValue(string(make([]byte, 100)))
+	if len(long) != maxLabelLength {
+		t.Errorf("sanitized value has %d characters, want %d", len(long), maxLabelLength)
+	}
+}
@@ -0,0 +1,163 @@
+package platform
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/component"
+)
+
+// defaultName is the template of the name of the function when the
+// configuration doesn't set one.
+const defaultName = "{{.App}}"
+
+// functionIDRegexp matches the names allowed by Cloud Functions: a letter
+// followed by up to 62 letters, digits, dashes or underscores, not ending
+// with a dash or an underscore.
+var functionIDRegexp = regexp.MustCompile(`^[a-zA-Z]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?$`)
+
+// functionIDV2Regexp matches the names allowed for 2nd gen functions, which
+// are also the names of their Cloud Run services: lowercase letters, digits
+// and dashes only.
+var functionIDV2Regexp = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// invalidNameChars matches the characters of the template values which are
+// not allowed in names.
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// workspaceConfig overrides the configuration for a Waypoint workspace.
+type workspaceConfig struct {
+	// Workspace is the name of the Waypoint workspace.
+	Workspace string `hcl:"workspace,label"`
+
+	// Name of the function in the workspace, see DeployConfig.Name.
+	Name string `hcl:"name,optional"`
+}
+
+// forWorkspace returns the configuration with the overrides of the
+// workspace of the job applied.
+func (d DeployConfig) forWorkspace(job *component.JobInfo) DeployConfig {
+	if job == nil {
+		return d
+	}
+
+	for _, w := range d.Workspaces {
+		if w.Workspace == job.Workspace && w.Name != "" {
+			d.Name = w.Name
+		}
+	}
+
+	return d
+}
+
+// nameData is the data available to the name template.
+type nameData struct {
+	App       string
+	Workspace string
+}
+
+// parseName parses the name template of the configuration and checks it
+// renders a valid name.
+func (d DeployConfig) parseName() (*template.Template, error) {
+	name := d.Name
+	if name == "" {
+		name = defaultName
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+
+	if _, err := renderName(tmpl, nameData{App: "app", Workspace: "default"}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// functionID returns the name of the function of the app, i.e. the last
+// segment of its resource name, rendered from the name template.
+func (d DeployConfig) functionID(source *component.Source, job *component.JobInfo, generation int64) (string, error) {
+	tmpl, err := d.parseName()
+	if err != nil {
+		return "", err
+	}
+
+	data := nameData{Workspace: "default"}
+	if source != nil {
+		data.App = source.App
+	}
+	if job != nil && job.Workspace != "" {
+		data.Workspace = job.Workspace
+	}
+
+	id, err := renderName(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	if generation == 2 {
+		id = strings.ToLower(id)
+		if !functionIDV2Regexp.MatchString(id) {
+			return "", fmt.Errorf(
+				"invalid name %q: 2nd gen function names must start with a letter, contain only "+
+					"lowercase letters, digits and dashes and be at most 63 characters long", id)
+		}
+	}
+
+	return id, nil
+}
+
+// renderName executes the name template with the values sanitized to the
+// characters allowed in names, and validates the result.
+func renderName(tmpl *template.Template, data nameData) (string, error) {
+	data.App = invalidNameChars.ReplaceAllString(data.App, "-")
+	data.Workspace = invalidNameChars.ReplaceAllString(data.Workspace, "-")
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+
+	id := strings.Trim(b.String(), "-")
+	if !functionIDRegexp.MatchString(id) {
+		return "", fmt.Errorf(
+			"invalid name %q: function names must start with a letter, contain only letters, digits, "+
+				"dashes and underscores and be at most 63 characters long", id)
+	}
+
+	return id, nil
+}
+
+// stagingSuffixLength is the length of the deployment ID suffix of staging
+// functions.
+const stagingSuffixLength = 10
+
+// stagingFunctionID returns the name of the staging function of a deployment
+// of the function: the name of the function followed by the end of the
+// deployment ID, or the current time when there is none, truncated so that
+// the name stays within the length allowed by Cloud Functions.
+func stagingFunctionID(functionID string, dconfig *component.DeploymentConfig) string {
+	var suffix string
+	if dconfig != nil && dconfig.Id != "" {
+		suffix = strings.ToLower(invalidNameChars.ReplaceAllString(dconfig.Id, ""))
+	}
+	if suffix == "" {
+		suffix = strconv.FormatInt(time.Now().Unix(), 36)
+	}
+	if len(suffix) > stagingSuffixLength {
+		suffix = suffix[len(suffix)-stagingSuffixLength:]
+	}
+
+	suffix = "-stg-" + suffix
+	if max := 63 - len(suffix); len(functionID) > max {
+		functionID = strings.TrimRight(functionID[:max], "-_")
+	}
+
+	return functionID + suffix
+}
@@ -0,0 +1,139 @@
+package platform
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/component"
+)
+
+func TestFunctionID(t *testing.T) {
+	cases := []struct {
+		name       string
+		template   string
+		app        string
+		workspace  string
+		generation int64
+		want       string
+		err        bool
+	}{
+		{name: "default", app: "helloworld", want: "helloworld"},
+		{name: "default workspace", template: "{{.App}}-{{.Workspace}}", app: "app", want: "app-default"},
+		{
+			name:      "workspace",
+			template:  "{{.App}}-{{.Workspace}}",
+			app:       "app",
+			workspace: "staging",
+			want:      "app-staging",
+		},
+		{
+			name:      "invalid characters",
+			template:  "{{.App}}-{{.Workspace}}",
+			app:       "my app!",
+			workspace: "feature/login",
+			want:      "my-app--feature-login",
+		},
+		{name: "leading and trailing invalid characters", app: "/app/", want: "app"},
+		{name: "63 characters", app: strings.Repeat("a", 63), want: strings.Repeat("a", 63)},
+		{name: "64 characters", app: strings.Repeat("a", 64), err: true},
+		{name: "starting with a digit", app: "1app", err: true},
+		{name: "starting with a letter from the template", template: "fn-{{.App}}", app: "1app", want: "fn-1app"},
+		{name: "trailing underscore", app: "app_", err: true},
+		{name: "empty", template: "{{.App}}", app: "", err: true},
+		{name: "unknown template key", template: "{{.Project}}", app: "app", err: true},
+		{name: "2nd gen lowercased", app: "HelloWorld", generation: 2, want: "helloworld"},
+		{name: "2nd gen underscore", app: "hello_world", generation: 2, err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DeployConfig{Name: tc.template}
+			source := &component.Source{App: tc.app}
+			job := &component.JobInfo{Workspace: tc.workspace}
+
+			got, err := config.functionID(source, job, tc.generation)
+			if tc.err {
+				if err == nil {
+					t.Errorf("functionID() = %q, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Errorf("functionID() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStagingFunctionID(t *testing.T) {
+	cases := []struct {
+		name       string
+		functionID string
+		id         string
+		want       string
+	}{
+		{
+			name:       "short name",
+			functionID: "helloworld",
+			id:         "01EJT7FXZQ0123456789",
+			want:       "helloworld-stg-0123456789",
+		},
+		{
+			name:       "short deployment ID",
+			functionID: "helloworld",
+			id:         "01ejt",
+			want:       "helloworld-stg-01ejt",
+		},
+		{
+			name:       "invalid characters in the deployment ID",
+			functionID: "helloworld",
+			id:         "01:EJ-T7",
+			want:       "helloworld-stg-01ej-t7",
+		},
+		{
+			name:       "name at the maximum length",
+			functionID: strings.Repeat("a", 48),
+			id:         "0123456789",
+			want:       strings.Repeat("a", 48) + "-stg-0123456789",
+		},
+		{
+			name:       "truncated name",
+			functionID: strings.Repeat("a", 63),
+			id:         "0123456789",
+			want:       strings.Repeat("a", 48) + "-stg-0123456789",
+		},
+		{
+			name:       "truncated name ending with a dash",
+			functionID: strings.Repeat("a", 47) + "-_b",
+			id:         "0123456789",
+			want:       strings.Repeat("a", 47) + "-stg-0123456789",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := stagingFunctionID(tc.functionID, &component.DeploymentConfig{Id: tc.id})
+			if got != tc.want {
+				t.Errorf("stagingFunctionID() = %q, want %q", got, tc.want)
+			}
+
+			if !functionIDRegexp.MatchString(got) {
+				t.Errorf("stagingFunctionID() = %q is not a valid function name", got)
+			}
+		})
+	}
+}
+
+func TestStagingFunctionIDWithoutDeploymentID(t *testing.T) {
+	got := stagingFunctionID(strings.Repeat("a", 63), nil)
+
+	if !regexp.MustCompile(`^a+-stg-[0-9a-z]+$`).MatchString(got) || len(got) > 63 {
+		t.Errorf("stagingFunctionID() = %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+package platform
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/cloudfunctions/v1"
+)
+
+// errPlanOnly is returned by deploy when plan_only is set, to stop Waypoint
+// from releasing a deployment that wasn't made.
+var errPlanOnly = errors.New("plan_only is set, no changes were deployed")
+
+// Actions describing how a field of the function changes.
+const (
+	changeAdded   = "added"
+	changeChanged = "changed"
+	changeRemoved = "removed"
+)
+
+// fieldChange describes the change of a single value of the function.
+type fieldChange struct {
+	// Field is the update mask path of the field, suffixed with the key
+	// for map and block fields, e.g. environmentVariables.FOO.
+	Field   string
+	Action  string
+	Current string
+	Desired string
+
+	// Sensitive is set when the values must not be displayed.
+	Sensitive bool
+}
+
+// diffFunction returns the changes needed to go from the current function to
+// the desired one. current may be nil if the function doesn't exist yet.
+func diffFunction(current, desired *cloudfunctions.CloudFunction) []fieldChange {
+	if current == nil {
+		current = &cloudfunctions.CloudFunction{}
+	}
+
+	var changes []fieldChange
+
+	for _, f := range updatableFields {
+		if f.same(current, desired) {
+			continue
+		}
+
+		cv, dv := f.values(current), f.values(desired)
+
+		keys := make([]string, 0, len(cv)+len(dv))
+		for k := range cv {
+			keys = append(keys, k)
+		}
+		for k := range dv {
+			if _, ok := cv[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			c, inCurrent := cv[k]
+			d, inDesired := dv[k]
+
+			change := fieldChange{Field: f.path, Current: c, Desired: d, Sensitive: f.sensitive}
+			if k != "" {
+				change.Field += "." + k
+			}
+
+			switch {
+			case !inCurrent:
+				change.Action = changeAdded
+			case !inDesired:
+				change.Action = changeRemoved
+			case c != d:
+				change.Action = changeChanged
+			default:
+				continue
+			}
+
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// sensitiveValue replaces the values of sensitive fields in the plan.
+const sensitiveValue = "(sensitive)"
+
+// renderPlan outputs the changes as a table. No other UI method must be
+// active while it is called.
+func renderPlan(ui terminal.UI, functionName string, changes []fieldChange) {
+	if len(changes) == 0 {
+		ui.Output("No configuration changes for '%s', only the source archive will be updated", functionName)
+		return
+	}
+
+	ui.Output("Configuration changes for '%s':", functionName, terminal.WithHeaderStyle())
+
+	colors := map[string]string{
+		changeAdded:   terminal.Green,
+		changeChanged: terminal.Yellow,
+		changeRemoved: terminal.Red,
+	}
+
+	tbl := terminal.NewTable("Field", "Change", "Current", "Desired")
+	for _, c := range changes {
+		current, desired := c.Current, c.Desired
+		if c.Sensitive {
+			if current != "" {
+				current = sensitiveValue
+			}
+			if desired != "" {
+				desired = sensitiveValue
+			}
+		}
+
+		color := colors[c.Action]
+		tbl.Rich(
+			[]string{c.Field, c.Action, current, desired},
+			[]string{color, color, color, color},
+		)
+	}
+
+	ui.Table(tbl)
+}
@@ -0,0 +1,484 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/cloudfunctions/v1"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
+)
+
+// Promote updates the production function of a staged deployment to the
+// source and configuration of its staging function, creating it if needed.
+// It returns the deployment of the production function.
+func Promote(ctx context.Context, st terminal.Status, deployment *Deployment) (*Deployment, error) {
+	if deployment.ProductionName == "" {
+		return nil, fmt.Errorf("deployment %s is not staged", deployment.Name)
+	}
+
+	st.Update("Promoting Google Cloud Function '" + deployment.Name + "' to '" + deployment.ProductionName + "'")
+
+	if deployment.Generation == 2 {
+		return promoteV2(ctx, st, deployment)
+	}
+
+	return promote(ctx, st, deployment)
+}
+
+func promote(ctx context.Context, st terminal.Status, deployment *Deployment) (*Deployment, error) {
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	staging, err := cloudfunctionsService.Projects.Locations.Functions.Get(deployment.Name).Context(ctx).Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching the staging function")
+		return nil, err
+	}
+
+	desired := promotedFunction(staging, deployment.ProductionName)
+	parent := parentName(deployment.ProductionName)
+
+	// The upload URL of the staging function can't be reused, its source
+	// is uploaded again for the production function.
+	if staging.SourceUploadUrl != "" {
+		desired.SourceUploadUrl, err = copySource(ctx, cloudfunctionsService, staging, parent)
+		if err != nil {
+			st.Step(terminal.StatusError, "Error copying the source of the staging function")
+			return nil, err
+		}
+	}
+
+	var op *cloudfunctions.Operation
+
+	current, err := cloudfunctionsService.Projects.Locations.Functions.Get(desired.Name).Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if !errors.As(err, &gerr) || gerr.Code != 404 {
+			st.Step(terminal.StatusError, "Error fetching function")
+			return nil, err
+		}
+
+		op, err = cloudfunctionsService.Projects.Locations.Functions.Create(parent, desired).Context(ctx).Do()
+	} else {
+		op, err = patchFunc(ctx, cloudfunctionsService, desired, promoteMask(current, desired))
+	}
+	if err != nil {
+		st.Step(terminal.StatusError, "Error promoting function")
+		return nil, err
+	}
+
+	op, err = cloudfunctionsutil.WaitForOperation(ctx, cloudfunctionsService, op, cloudfunctionsutil.WaitOptions{
+		Status:  st,
+		Message: "Promoting Google Cloud Function '" + deployment.Name + "'",
+	})
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching promotion status")
+		return nil, err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Error promoting function")
+		return nil, errors.New(op.Error.Message)
+	}
+
+	var cfresp cloudfunctions.CloudFunction
+	if err := json.Unmarshal(op.Response, &cfresp); err != nil {
+		st.Step(terminal.StatusError, "Error reading the response data but function successfully promoted")
+		return nil, err
+	}
+
+	st.Step(terminal.StatusOK, fmt.Sprintf(
+		"Google Cloud Function '%s' promoted to '%s' 'v%d'",
+		deployment.Name, cfresp.Name, cfresp.VersionId,
+	))
+
+	promoted := Deployment{
+		Name:         cfresp.Name,
+		Version:      cfresp.VersionId,
+		Generation:   1,
+		DeploymentId: deployment.DeploymentId,
+	}
+	if t := cfresp.HttpsTrigger; t != nil {
+		promoted.Url = t.Url
+	}
+
+	return &promoted, nil
+}
+
+// promotedFunction returns a copy of fn named name, without its output only
+// fields and staging label, to create or update a function with the source
+// and configuration of fn.
+func promotedFunction(staging *cloudfunctions.CloudFunction, name string) *cloudfunctions.CloudFunction {
+	fn := *staging
+	fn.Name = name
+
+	// Output only fields.
+	fn.BuildId = ""
+	fn.BuildName = ""
+	fn.Status = ""
+	fn.UpdateTime = ""
+	fn.VersionId = 0
+	fn.SourceToken = ""
+	fn.ServerResponse = googleapi.ServerResponse{}
+
+	if t := staging.HttpsTrigger; t != nil {
+		fn.HttpsTrigger = &cloudfunctions.HttpsTrigger{SecurityLevel: t.SecurityLevel}
+	}
+
+	fn.Labels = map[string]string{}
+	for k, v := range staging.Labels {
+		if k != stagingOfLabel {
+			fn.Labels[k] = v
+		}
+	}
+
+	return &fn
+}
+
+// promoteMask returns the update mask replacing all the fields managed by the
+// plugin, and the source, of the current function with the ones of desired.
+func promoteMask(current, desired *cloudfunctions.CloudFunction) []string {
+	mask := sourceMask(current, desired)
+	for _, f := range updatableFields {
+		mask = append(mask, f.path)
+	}
+
+	return mask
+}
+
+// copySource downloads the source of the current version of the function
+// and uploads it to a new upload URL in parent, which is returned. The source
+// is downloaded to a temporary file so that the upload can be retried.
+func copySource(
+	ctx context.Context,
+	service *cloudfunctions.Service,
+	fn *cloudfunctions.CloudFunction,
+	parent string,
+) (string, error) {
+	download, err := service.Projects.Locations.Functions.
+		GenerateDownloadUrl(fn.Name, &cloudfunctions.GenerateDownloadUrlRequest{VersionId: uint64(fn.VersionId)}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", err
+	}
+
+	upload, err := service.Projects.Locations.Functions.
+		GenerateUploadUrl(parent, &cloudfunctions.GenerateUploadUrlRequest{}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile("", "waypoint-cloudfunctions-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := downloadSource(ctx, download.DownloadUrl, file); err != nil {
+		return "", err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	hashes, err := cloudfunctionsutil.HashFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	err = cloudfunctionsutil.UploadToURL(ctx, upload.UploadUrl, file, fi.Size(), hashes, cloudfunctionsutil.UploadOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error uploading the source: %w", err)
+	}
+
+	return upload.UploadUrl, nil
+}
+
+// downloadSource writes the source archive at downloadURL to file.
+func downloadSource(ctx context.Context, downloadURL string, file *os.File) error {
+	ctx, cancel := context.WithTimeout(ctx, cloudfunctionsutil.UploadAttemptTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cloudfunctionsutil.UploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return errors.New("error downloading the source: " + resp.Status)
+	}
+
+	_, err = io.Copy(file, resp.Body)
+
+	return err
+}
+
+func promoteV2(ctx context.Context, st terminal.Status, deployment *Deployment) (*Deployment, error) {
+	cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	staging, err := cloudfunctionsService.Projects.Locations.Functions.Get(deployment.Name).Context(ctx).Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching the staging function")
+		return nil, err
+	}
+
+	fn := promotedFunctionV2(staging, deployment.ProductionName)
+
+	var op *cloudfunctionsv2.Operation
+
+	_, err = cloudfunctionsService.Projects.Locations.Functions.Get(fn.Name).Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if !errors.As(err, &gerr) || gerr.Code != 404 {
+			st.Step(terminal.StatusError, "Error fetching function")
+			return nil, err
+		}
+
+		op, err = cloudfunctionsService.Projects.Locations.Functions.
+			Create(parentName(fn.Name), fn).
+			FunctionId(fn.Name[strings.LastIndex(fn.Name, "/")+1:]).
+			Context(ctx).
+			Do()
+	} else {
+		op, err = cloudfunctionsService.Projects.Locations.Functions.
+			Patch(fn.Name, fn).
+			UpdateMask(strings.Join(updateMaskV2, ",")).
+			Context(ctx).
+			Do()
+	}
+	if err != nil {
+		st.Step(terminal.StatusError, "Error promoting function")
+		return nil, err
+	}
+
+	op, err = cloudfunctionsutil.WaitForOperationV2(ctx, cloudfunctionsService, op, cloudfunctionsutil.WaitOptions{
+		Status:  st,
+		Message: "Promoting Google Cloud Function '" + deployment.Name + "'",
+	})
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching promotion status")
+		return nil, err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Error promoting function")
+		return nil, errors.New(op.Error.Message)
+	}
+
+	var fnresp cloudfunctionsv2.Function
+	if err := json.Unmarshal(op.Response, &fnresp); err != nil {
+		st.Step(terminal.StatusError, "Error reading the response data but function successfully promoted")
+		return nil, err
+	}
+
+	promoted := Deployment{Name: fnresp.Name, Generation: 2, DeploymentId: deployment.DeploymentId}
+	if c := fnresp.ServiceConfig; c != nil {
+		promoted.Service = c.Service
+		promoted.Revision = c.Revision
+		if fnresp.EventTrigger == nil {
+			promoted.Url = c.Uri
+		}
+
+		st.Step(terminal.StatusOK, fmt.Sprintf(
+			"Google Cloud Function '%s' promoted to '%s' '%s'",
+			deployment.Name, fnresp.Name, c.Revision,
+		))
+	}
+
+	return &promoted, nil
+}
+
+// promotedFunctionV2 is the equivalent of promotedFunction for 2nd gen
+// functions.
+func promotedFunctionV2(staging *cloudfunctionsv2.Function, name string) *cloudfunctionsv2.Function {
+	fn := &cloudfunctionsv2.Function{
+		Name:        name,
+		Description: staging.Description,
+		Labels:      map[string]string{},
+	}
+
+	for k, v := range staging.Labels {
+		if k != stagingOfLabel {
+			fn.Labels[k] = v
+		}
+	}
+
+	if c := staging.BuildConfig; c != nil {
+		buildConfig := *c
+		buildConfig.Build = ""
+		fn.BuildConfig = &buildConfig
+	}
+
+	if c := staging.ServiceConfig; c != nil {
+		serviceConfig := *c
+		serviceConfig.Service = ""
+		serviceConfig.Uri = ""
+		serviceConfig.Revision = ""
+		fn.ServiceConfig = &serviceConfig
+	}
+
+	if t := staging.EventTrigger; t != nil {
+		eventTrigger := *t
+		eventTrigger.Trigger = ""
+		fn.EventTrigger = &eventTrigger
+	}
+
+	return fn
+}
+
+// CleanupStaging deletes the staging functions of the production function of
+// a staged deployment which are older than the staging function of the
+// deployment itself, so that releasing an older deployment keeps the newer
+// ones. Functions which can't be deleted are reported as warnings.
+func CleanupStaging(ctx context.Context, st terminal.Status, deployment *Deployment) error {
+	production := deployment.ProductionName
+
+	st.Update("Deleting stale staging functions of '" + production + "'")
+
+	stagingOf := sanitizeLabelValue(production[strings.LastIndex(production, "/")+1:])
+
+	// Staging functions by name, described as the deployment which last
+	// updated them so that they pass the ownership check of deleteFunction,
+	// and their update times. Staging functions are never updated once
+	// deployed, so that these are their deployment times.
+	staging := map[string]*Deployment{}
+	updated := map[string]string{}
+
+	if deployment.Generation == 2 {
+		cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = cloudfunctionsService.Projects.Locations.Functions.List(parentName(production)).
+			Filter(fmt.Sprintf("labels.%s=%s", stagingOfLabel, stagingOf)).
+			Pages(ctx, func(resp *cloudfunctionsv2.ListFunctionsResponse) error {
+				for _, fn := range resp.Functions {
+					if fn.Labels[stagingOfLabel] == stagingOf {
+						d := &Deployment{
+							Name:         fn.Name,
+							Generation:   2,
+							DeploymentId: fn.Labels[deploymentIDLabel],
+						}
+						if fn.ServiceConfig != nil {
+							d.Revision = fn.ServiceConfig.Revision
+						}
+
+						staging[fn.Name] = d
+						updated[fn.Name] = fn.UpdateTime
+					}
+				}
+
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	} else {
+		cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = cloudfunctionsService.Projects.Locations.Functions.List(parentName(production)).
+			Pages(ctx, func(resp *cloudfunctions.ListFunctionsResponse) error {
+				for _, fn := range resp.Functions {
+					if fn.Labels[stagingOfLabel] == stagingOf {
+						staging[fn.Name] = &Deployment{
+							Name:         fn.Name,
+							Version:      fn.VersionId,
+							Generation:   1,
+							DeploymentId: fn.Labels[deploymentIDLabel],
+						}
+						updated[fn.Name] = fn.UpdateTime
+					}
+				}
+
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	released, err := time.Parse(time.RFC3339Nano, updated[deployment.Name])
+	if err != nil {
+		st.Step(terminal.StatusWarn, "Unable to find the update time of '"+deployment.Name+"', no staging function deleted")
+		return nil
+	}
+
+	var stale []string
+	for name, updateTime := range updated {
+		t, err := time.Parse(time.RFC3339Nano, updateTime)
+		if err != nil || !t.Before(released) {
+			continue
+		}
+
+		stale = append(stale, name)
+	}
+	sort.Strings(stale)
+
+	for _, name := range stale {
+		stagingDeployment := staging[name]
+		opts := cloudfunctionsutil.WaitOptions{Status: st, Message: "Deleting staging function '" + name + "'"}
+
+		var (
+			deleted bool
+			err     error
+		)
+		if deployment.Generation == 2 {
+			deleted, err = deleteFunctionV2(ctx, st, stagingDeployment, opts)
+		} else {
+			deleted, err = deleteFunction(ctx, st, stagingDeployment, opts)
+		}
+		if err != nil {
+			st.Step(terminal.StatusWarn, "Unable to delete the staging function '"+name+"': "+err.Error())
+			continue
+		}
+
+		if deleted {
+			st.Step(terminal.StatusOK, "Staging function '"+name+"' deleted")
+		}
+	}
+
+	return nil
+}
+
+// parentName returns the location of a function given its resource name.
+func parentName(name string) string {
+	if i := strings.Index(name, "/functions/"); i >= 0 {
+		return name[:i]
+	}
+
+	return name
+}
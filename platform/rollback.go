@@ -0,0 +1,96 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/cloudfunctions/v1"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
+)
+
+// functionSource returns the location of the source of the function, its
+// Cloud Storage archive or repository. It is empty for sources uploaded to a
+// signed URL, which is only valid for a single upload: their rollback relies
+// on GenerateDownloadUrl still serving the source of the previous version.
+func functionSource(cf *cloudfunctions.CloudFunction) string {
+	switch {
+	case cf.SourceArchiveUrl != "":
+		return cf.SourceArchiveUrl
+	case cf.SourceRepository != nil:
+		return cf.SourceRepository.Url
+	default:
+		return ""
+	}
+}
+
+// rollback updates the 1st gen function of the deployment back to the source
+// and configuration of its previous version.
+func rollback(
+	ctx context.Context,
+	st terminal.Status,
+	deployment *Deployment,
+	previous *cloudfunctions.CloudFunction,
+	opts cloudfunctionsutil.WaitOptions,
+) error {
+	st.Step(terminal.StatusWarn, fmt.Sprintf(
+		"Rolling back Google Cloud Function '%s' from 'v%d' to 'v%d'",
+		deployment.Name, deployment.Version, previous.VersionId,
+	))
+
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := cloudfunctionsService.Projects.Locations.Functions.Get(previous.Name).Context(ctx).Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching function")
+		return err
+	}
+
+	desired := promotedFunction(previous, previous.Name)
+
+	// Upload URLs can't be reused, the source of the previous version is
+	// uploaded again.
+	if previous.SourceUploadUrl != "" {
+		desired.SourceUploadUrl, err = copySource(ctx, cloudfunctionsService, previous, parentName(previous.Name))
+		if err != nil {
+			st.Step(terminal.StatusError, "Error copying the source of the previous version")
+			return err
+		}
+	}
+
+	op, err := patchFunc(ctx, cloudfunctionsService, desired, promoteMask(current, desired))
+	if err != nil {
+		st.Step(terminal.StatusError, "Error rolling back function")
+		return err
+	}
+
+	op, err = cloudfunctionsutil.WaitForOperation(ctx, cloudfunctionsService, op, opts)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error fetching rollback status")
+		return err
+	}
+
+	if op.Error != nil {
+		st.Step(terminal.StatusError, "Error rolling back function")
+		return errors.New(op.Error.Message)
+	}
+
+	var cfresp cloudfunctions.CloudFunction
+	if err := json.Unmarshal(op.Response, &cfresp); err != nil {
+		st.Step(terminal.StatusError, "Error reading the response data but function successfully rolled back")
+		return err
+	}
+
+	st.Step(terminal.StatusOK, fmt.Sprintf(
+		"Google Cloud Function rolled back from 'v%d' to the source and configuration of 'v%d', now 'v%d'",
+		deployment.Version, previous.VersionId, cfresp.VersionId,
+	))
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package platform
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/cloudfunctions/v1"
+)
+
+// sampleEventMessage is the data of the Pub/Sub message generated when the
+// sample event doesn't set its data.
+const sampleEventMessage = "Sample event sent by Waypoint"
+
+type sampleEvent struct {
+	// Data of the event as JSON, e.g. a Pub/Sub message
+	// `{"data": "<base64>", "attributes": {}}` or a Cloud Storage object
+	// `{"bucket": "my-bucket", "name": "file.txt"}`. Defaults to an event
+	// generated from the event trigger.
+	Data string `hcl:"data,optional"`
+}
+
+// validate checks the sample event configuration.
+func (e *sampleEvent) validate() error {
+	if e.Data != "" && !json.Valid([]byte(e.Data)) {
+		return errors.New("sample_event data must be valid JSON")
+	}
+
+	return nil
+}
+
+// data returns the data of the event sent to the function: the configured
+// data or an event generated for the trigger.
+func (e *sampleEvent) data(trigger *eventTrigger) (string, error) {
+	if e.Data != "" {
+		return e.Data, nil
+	}
+
+	var event interface{}
+
+	switch {
+	case strings.Contains(trigger.EventType, "pubsub"):
+		event = map[string]interface{}{
+			"data":       base64.StdEncoding.EncodeToString([]byte(sampleEventMessage)),
+			"attributes": map[string]string{},
+		}
+	case strings.Contains(trigger.EventType, "storage"):
+		bucket := trigger.Resource
+		if i := strings.LastIndex(bucket, "/buckets/"); i >= 0 {
+			bucket = bucket[i+len("/buckets/"):]
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		event = map[string]interface{}{
+			"bucket":         bucket,
+			"name":           "waypoint-sample-event.txt",
+			"contentType":    "text/plain",
+			"size":           "0",
+			"timeCreated":    now,
+			"updated":        now,
+			"metageneration": "1",
+		}
+	default:
+		event = map[string]interface{}{}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// invoke calls the 1st gen function name with the sample event, and returns
+// an error if the execution fails.
+func (e *sampleEvent) invoke(
+	ctx context.Context,
+	log hclog.Logger,
+	st terminal.Status,
+	name string,
+	trigger *eventTrigger,
+) error {
+	st.Update("Invoking Google Cloud Function '" + name + "' with a sample event")
+
+	data, err := e.data(trigger)
+	if err != nil {
+		return err
+	}
+
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cloudfunctionsService.Projects.Locations.Functions.
+		Call(name, &cloudfunctions.CallFunctionRequest{Data: data}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		st.Step(terminal.StatusError, "Error invoking function")
+		return err
+	}
+
+	log.Debug("sample event execution", "execution-id", resp.ExecutionId, "result", resp.Result)
+
+	if resp.Error != "" {
+		st.Step(terminal.StatusError, "Sample event failed, execution '"+resp.ExecutionId+"'")
+		return fmt.Errorf("execution %s of the sample event failed: %s", resp.ExecutionId, resp.Error)
+	}
+
+	st.Step(terminal.StatusOK, "Sample event processed, execution '"+resp.ExecutionId+"'")
+
+	return nil
+}
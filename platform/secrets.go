@@ -0,0 +1,296 @@
+package platform
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudfunctions/v1"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+)
+
+// secretVersionRegexp matches the versions of a secret: a version number or
+// latest.
+var secretVersionRegexp = regexp.MustCompile(`^([1-9][0-9]*|latest)$`)
+
+// secretNameRegexp matches the IDs of Secret Manager secrets.
+var secretNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,255}$`)
+
+type secretVolume struct {
+	// MountPath is the path within the container where the secret is
+	// mounted, e.g. "/etc/secrets".
+	MountPath string `hcl:"mount_path"`
+
+	// Secret is the name of the secret, either its ID in the project of the
+	// function or its resource name in the format
+	// `projects/{project}/secrets/{secret}`.
+	Secret string `hcl:"secret"`
+
+	// Versions maps the path of the files, relative to the mount path, to
+	// the version of the secret they contain. Defaults to the latest version
+	// mounted as a file named after the secret.
+	Versions map[string]string `hcl:"versions,optional"`
+}
+
+// secretRef is a reference to a Secret Manager secret.
+type secretRef struct {
+	// Project of the secret, empty for the project of the function.
+	Project string
+	Secret  string
+	Version string
+}
+
+func (r secretRef) String() string {
+	name := r.Secret
+	if r.Project != "" {
+		name = fmt.Sprintf("projects/%s/secrets/%s", r.Project, r.Secret)
+	}
+
+	if r.Version == "" {
+		return name
+	}
+
+	return name + ":" + r.Version
+}
+
+// parseSecretRef parses a reference to a secret, either in the format
+// `{secret}` or `projects/{project}/secrets/{secret}`, optionally followed by
+// `:{version}` or `/versions/{version}` if withVersion is set.
+func parseSecretRef(ref string, withVersion bool) (secretRef, error) {
+	var r secretRef
+
+	name := ref
+	if withVersion {
+		r.Version = "latest"
+
+		if i := strings.Index(name, "/versions/"); i >= 0 {
+			name, r.Version = name[:i], name[i+len("/versions/"):]
+		} else if i := strings.LastIndex(name, ":"); i >= 0 {
+			name, r.Version = name[:i], name[i+1:]
+		}
+
+		if !secretVersionRegexp.MatchString(r.Version) {
+			return r, fmt.Errorf("invalid version %q in secret reference %q", r.Version, ref)
+		}
+	}
+
+	r.Secret = name
+	if parts := strings.Split(name, "/"); len(parts) > 1 {
+		if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "secrets" {
+			return r, fmt.Errorf("secret reference %q must be in the format projects/{project}/secrets/{secret}", ref)
+		}
+
+		r.Project, r.Secret = parts[1], parts[3]
+	}
+
+	if !secretNameRegexp.MatchString(r.Secret) {
+		return r, fmt.Errorf("invalid secret name %q in secret reference %q", r.Secret, ref)
+	}
+
+	return r, nil
+}
+
+// validateSecrets checks the references to secrets of the configuration.
+func (d DeployConfig) validateSecrets() error {
+	for key, ref := range d.SecretEnvironmentVariables {
+		if _, ok := d.EnvironmentVariables[key]; ok {
+			return fmt.Errorf("%s is defined in both environment_variables and secret_environment_variables", key)
+		}
+
+		if _, err := parseSecretRef(ref, true); err != nil {
+			return fmt.Errorf("invalid secret_environment_variables %s: %w", key, err)
+		}
+	}
+
+	mountPaths := map[string]bool{}
+
+	for _, v := range d.SecretVolumes {
+		if !path.IsAbs(v.MountPath) {
+			return fmt.Errorf("secret_volumes mount_path %q must be an absolute path", v.MountPath)
+		}
+
+		if mountPaths[v.MountPath] {
+			return fmt.Errorf("secret_volumes mount_path %q is used more than once", v.MountPath)
+		}
+		mountPaths[v.MountPath] = true
+
+		if _, err := parseSecretRef(v.Secret, false); err != nil {
+			return fmt.Errorf("invalid secret_volumes secret: %w", err)
+		}
+
+		for p, version := range v.Versions {
+			if p == "" || path.IsAbs(p) {
+				return fmt.Errorf("secret_volumes versions path %q must be a relative path", p)
+			}
+
+			if !secretVersionRegexp.MatchString(version) {
+				return fmt.Errorf("invalid secret_volumes version %q for path %q", version, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// secretEnvVarsToCF returns the secret environment variables in the format
+// expected by the cloudfunctions.Service. The references are validated by
+// ConfigSet.
+func (d DeployConfig) secretEnvVarsToCF() []*cloudfunctions.SecretEnvVar {
+	var vars []*cloudfunctions.SecretEnvVar
+
+	for _, key := range sortedKeys(d.SecretEnvironmentVariables) {
+		r, _ := parseSecretRef(d.SecretEnvironmentVariables[key], true)
+
+		vars = append(vars, &cloudfunctions.SecretEnvVar{
+			Key:       key,
+			ProjectId: r.Project,
+			Secret:    r.Secret,
+			Version:   r.Version,
+		})
+	}
+
+	return vars
+}
+
+// secretVolumesToCF returns the secret volumes in the format expected by the
+// cloudfunctions.Service.
+func (d DeployConfig) secretVolumesToCF() []*cloudfunctions.SecretVolume {
+	var volumes []*cloudfunctions.SecretVolume
+
+	for _, v := range d.SecretVolumes {
+		r, _ := parseSecretRef(v.Secret, false)
+
+		volume := cloudfunctions.SecretVolume{
+			MountPath: v.MountPath,
+			ProjectId: r.Project,
+			Secret:    r.Secret,
+		}
+
+		for _, p := range sortedKeys(v.Versions) {
+			volume.Versions = append(volume.Versions, &cloudfunctions.SecretVersion{
+				Path:    p,
+				Version: v.Versions[p],
+			})
+		}
+
+		volumes = append(volumes, &volume)
+	}
+
+	return volumes
+}
+
+// secretEnvVarsToCFv2 is the equivalent of secretEnvVarsToCF for the v2 API.
+func (d DeployConfig) secretEnvVarsToCFv2() []*cloudfunctionsv2.SecretEnvVar {
+	var vars []*cloudfunctionsv2.SecretEnvVar
+
+	for _, v := range d.secretEnvVarsToCF() {
+		vars = append(vars, &cloudfunctionsv2.SecretEnvVar{
+			Key:       v.Key,
+			ProjectId: v.ProjectId,
+			Secret:    v.Secret,
+			Version:   v.Version,
+		})
+	}
+
+	return vars
+}
+
+// secretVolumesToCFv2 is the equivalent of secretVolumesToCF for the v2 API.
+func (d DeployConfig) secretVolumesToCFv2() []*cloudfunctionsv2.SecretVolume {
+	var volumes []*cloudfunctionsv2.SecretVolume
+
+	for _, v := range d.secretVolumesToCF() {
+		volume := cloudfunctionsv2.SecretVolume{
+			MountPath: v.MountPath,
+			ProjectId: v.ProjectId,
+			Secret:    v.Secret,
+		}
+
+		for _, version := range v.Versions {
+			volume.Versions = append(volume.Versions, &cloudfunctionsv2.SecretVersion{
+				Path:    version.Path,
+				Version: version.Version,
+			})
+		}
+
+		volumes = append(volumes, &volume)
+	}
+
+	return volumes
+}
+
+// secretEnvVarsValues flattens the secret environment variables of the
+// function into references to the secrets, the values of the secrets are
+// never read.
+func secretEnvVarsValues(cf *cloudfunctions.CloudFunction) map[string]string {
+	if len(cf.SecretEnvironmentVariables) == 0 {
+		return nil
+	}
+
+	values := map[string]string{}
+	for _, v := range cf.SecretEnvironmentVariables {
+		values[v.Key] = secretRef{Project: v.ProjectId, Secret: v.Secret, Version: v.Version}.String()
+	}
+
+	return values
+}
+
+// secretVolumesValues flattens the secret volumes of the function by mount
+// path.
+func secretVolumesValues(cf *cloudfunctions.CloudFunction) map[string]string {
+	if len(cf.SecretVolumes) == 0 {
+		return nil
+	}
+
+	values := map[string]string{}
+	for _, v := range cf.SecretVolumes {
+		var versions []string
+		for _, version := range v.Versions {
+			versions = append(versions, version.Path+"="+version.Version)
+		}
+
+		value := secretRef{Project: v.ProjectId, Secret: v.Secret}.String()
+		if len(versions) > 0 {
+			value += " (" + strings.Join(versions, ", ") + ")"
+		}
+
+		values[v.MountPath] = value
+	}
+
+	return values
+}
+
+// equalSecretRefs compares flattened secret references. The live function
+// always reports the project of the secrets, so it is ignored when not
+// configured.
+func equalSecretRefs(current, desired map[string]string) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+
+	for k, d := range desired {
+		c, ok := current[k]
+		if !ok {
+			return false
+		}
+
+		if c != d && !strings.HasSuffix(c, "/secrets/"+d) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,164 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	cases := []struct {
+		ref         string
+		withVersion bool
+		want        secretRef
+		wantErr     string
+	}{
+		{ref: "token", withVersion: true, want: secretRef{Secret: "token", Version: "latest"}},
+		{ref: "token:3", withVersion: true, want: secretRef{Secret: "token", Version: "3"}},
+		{ref: "token:latest", withVersion: true, want: secretRef{Secret: "token", Version: "latest"}},
+		{
+			ref:         "projects/p/secrets/token",
+			withVersion: true,
+			want:        secretRef{Project: "p", Secret: "token", Version: "latest"},
+		},
+		{
+			ref:         "projects/p/secrets/token/versions/2",
+			withVersion: true,
+			want:        secretRef{Project: "p", Secret: "token", Version: "2"},
+		},
+		{
+			ref:         "projects/p/secrets/token:2",
+			withVersion: true,
+			want:        secretRef{Project: "p", Secret: "token", Version: "2"},
+		},
+		{ref: "token", want: secretRef{Secret: "token"}},
+		{ref: "projects/p/secrets/token", want: secretRef{Project: "p", Secret: "token"}},
+		{ref: "token:0", withVersion: true, wantErr: "invalid version"},
+		{ref: "token:", withVersion: true, wantErr: "invalid version"},
+		{ref: "token:3", wantErr: "invalid secret name"},
+		{ref: "projects/p/token", withVersion: true, wantErr: "must be in the format"},
+		{ref: "projects//secrets/token", wantErr: "must be in the format"},
+		{ref: "", wantErr: "invalid secret name"},
+	}
+
+	for _, tc := range cases {
+		got, err := parseSecretRef(tc.ref, tc.withVersion)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("parseSecretRef(%q) error = %v, want %q", tc.ref, err, tc.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseSecretRef(%q) error = %v", tc.ref, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("parseSecretRef(%q) = %+v, want %+v", tc.ref, got, tc.want)
+		}
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  DeployConfig
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: DeployConfig{
+				EnvironmentVariables:       map[string]string{"A": "1"},
+				SecretEnvironmentVariables: map[string]string{"TOKEN": "token:1"},
+				SecretVolumes: []secretVolume{
+					{MountPath: "/etc/secrets", Secret: "projects/p/secrets/cert", Versions: map[string]string{"cert.pem": "latest"}},
+					{MountPath: "/etc/keys", Secret: "key"},
+				},
+			},
+		},
+		{
+			name: "variable defined twice",
+			config: DeployConfig{
+				EnvironmentVariables:       map[string]string{"TOKEN": "1"},
+				SecretEnvironmentVariables: map[string]string{"TOKEN": "token"},
+			},
+			wantErr: "defined in both",
+		},
+		{
+			name:    "invalid variable reference",
+			config:  DeployConfig{SecretEnvironmentVariables: map[string]string{"TOKEN": "token:v1"}},
+			wantErr: "invalid secret_environment_variables TOKEN",
+		},
+		{
+			name:    "relative mount path",
+			config:  DeployConfig{SecretVolumes: []secretVolume{{MountPath: "secrets", Secret: "cert"}}},
+			wantErr: "must be an absolute path",
+		},
+		{
+			name: "mount path used twice",
+			config: DeployConfig{SecretVolumes: []secretVolume{
+				{MountPath: "/etc/secrets", Secret: "cert"},
+				{MountPath: "/etc/secrets", Secret: "key"},
+			}},
+			wantErr: "used more than once",
+		},
+		{
+			name:    "volume secret with a version",
+			config:  DeployConfig{SecretVolumes: []secretVolume{{MountPath: "/etc/secrets", Secret: "cert:1"}}},
+			wantErr: "invalid secret_volumes secret",
+		},
+		{
+			name: "absolute version path",
+			config: DeployConfig{SecretVolumes: []secretVolume{
+				{MountPath: "/etc/secrets", Secret: "cert", Versions: map[string]string{"/cert.pem": "1"}},
+			}},
+			wantErr: "must be a relative path",
+		},
+		{
+			name: "invalid version",
+			config: DeployConfig{SecretVolumes: []secretVolume{
+				{MountPath: "/etc/secrets", Secret: "cert", Versions: map[string]string{"cert.pem": "first"}},
+			}},
+			wantErr: "invalid secret_volumes version",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.validateSecrets()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateSecrets() error = %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("validateSecrets() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEqualSecretRefs(t *testing.T) {
+	current := map[string]string{"TOKEN": "projects/p/secrets/token:1"}
+
+	if !equalSecretRefs(current, map[string]string{"TOKEN": "token:1"}) {
+		t.Error("secret of the function project is not equal to its resource name")
+	}
+
+	if !equalSecretRefs(current, map[string]string{"TOKEN": "projects/p/secrets/token:1"}) {
+		t.Error("identical references are not equal")
+	}
+
+	if equalSecretRefs(current, map[string]string{"TOKEN": "token:2"}) {
+		t.Error("different versions are equal")
+	}
+
+	if equalSecretRefs(current, map[string]string{"TOKEN": "ken:1"}) {
+		t.Error("secret whose name is a suffix is equal")
+	}
+}
@@ -0,0 +1,176 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/idtoken"
+)
+
+// Defaults of the smoke test.
+const (
+	defaultSmokeTestStatus  = http.StatusOK
+	defaultSmokeTestTimeout = time.Minute
+
+	// smokeTestInterval is the delay between two attempts, a new function
+	// may fail requests for a few seconds, e.g. until IAM changes propagate.
+	smokeTestInterval = 5 * time.Second
+
+	// maxSmokeTestBody is the length of the response body read and reported.
+	maxSmokeTestBody = 64 << 10
+)
+
+type smokeTest struct {
+	// Method of the request, defaults to GET.
+	Method string `hcl:"method,optional"`
+
+	// Path of the request, relative to the URL of the function.
+	Path string `hcl:"path,optional"`
+
+	// Body of the request.
+	Body string `hcl:"body,optional"`
+
+	// Headers of the request.
+	Headers map[string]string `hcl:"headers,optional"`
+
+	// ExpectedStatus is the expected status code of the response, defaults
+	// to 200.
+	ExpectedStatus int `hcl:"expected_status,optional"`
+
+	// BodyRegex, if set, must match the body of the response.
+	BodyRegex string `hcl:"body_regex,optional"`
+
+	// Timeout is the time during which the request is retried until it
+	// succeeds, e.g. "2m". Defaults to 1 minute.
+	Timeout string `hcl:"timeout,optional"`
+}
+
+// validate checks the smoke test configuration.
+func (t *smokeTest) validate() error {
+	if t.BodyRegex != "" {
+		if _, err := regexp.Compile(t.BodyRegex); err != nil {
+			return fmt.Errorf("invalid smoke_test body_regex: %w", err)
+		}
+	}
+
+	if t.Timeout != "" {
+		if _, err := time.ParseDuration(t.Timeout); err != nil {
+			return fmt.Errorf("invalid smoke_test timeout %q: %w", t.Timeout, err)
+		}
+	}
+
+	if t.ExpectedStatus != 0 && (t.ExpectedStatus < 100 || t.ExpectedStatus > 599) {
+		return fmt.Errorf("invalid smoke_test expected_status %d", t.ExpectedStatus)
+	}
+
+	return nil
+}
+
+// run sends the request to the function until the response is the expected
+// one or the timeout is reached. An identity token for the URL of the
+// function is sent when the credentials can provide one, so that functions
+// which aren't public can be tested.
+func (t *smokeTest) run(ctx context.Context, log hclog.Logger, st terminal.Status, functionURL string) error {
+	if functionURL == "" {
+		return errors.New("the function has no URL to test")
+	}
+
+	// The configuration is validated by ConfigSet.
+	timeout, _ := time.ParseDuration(t.Timeout)
+	if timeout <= 0 {
+		timeout = defaultSmokeTestTimeout
+	}
+
+	var bodyRegex *regexp.Regexp
+	if t.BodyRegex != "" {
+		bodyRegex = regexp.MustCompile(t.BodyRegex)
+	}
+
+	client, err := idtoken.NewClient(ctx, functionURL)
+	if err != nil {
+		log.Debug("unable to get an identity token, testing the function without it", "error", err)
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	url := functionURL
+	if t.Path != "" {
+		url = strings.TrimSuffix(functionURL, "/") + "/" + strings.TrimPrefix(t.Path, "/")
+	}
+
+	st.Update("Testing Google Cloud Function at " + url)
+
+	for {
+		err = t.check(ctx, client, url, bodyRegex)
+		if err == nil {
+			st.Step(terminal.StatusOK, "Smoke test passed: "+t.method()+" "+url)
+			return nil
+		}
+
+		log.Debug("smoke test failed, retrying", "url", url, "error", err)
+
+		select {
+		case <-ctx.Done():
+			st.Step(terminal.StatusError, "Smoke test failed: "+t.method()+" "+url)
+			return fmt.Errorf("smoke test of %s failed after %s: %w", url, timeout, err)
+		case <-time.After(smokeTestInterval):
+		}
+	}
+}
+
+// check sends the request once and checks the response.
+func (t *smokeTest) check(ctx context.Context, client *http.Client, url string, bodyRegex *regexp.Regexp) error {
+	req, err := http.NewRequestWithContext(ctx, t.method(), url, strings.NewReader(t.Body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSmokeTestBody))
+	if err != nil {
+		return err
+	}
+
+	expected := t.ExpectedStatus
+	if expected == 0 {
+		expected = defaultSmokeTestStatus
+	}
+
+	if resp.StatusCode != expected {
+		return fmt.Errorf("expected status %d, got %s: %s", expected, resp.Status, body)
+	}
+
+	if bodyRegex != nil && !bodyRegex.Match(body) {
+		return fmt.Errorf("response body doesn't match %q: %s", bodyRegex, body)
+	}
+
+	return nil
+}
+
+func (t *smokeTest) method() string {
+	if t.Method == "" {
+		return http.MethodGet
+	}
+
+	return strings.ToUpper(t.Method)
+}
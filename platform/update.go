@@ -0,0 +1,287 @@
+package platform
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/cloudfunctions/v1"
+)
+
+// Default values set by Google Cloud Functions when the corresponding field
+// is left empty. They are used to avoid reporting a field as changed when the
+// configuration doesn't set it and the live function uses the default.
+const (
+	defaultAvailableMemoryMB          = 256
+	defaultTimeout                    = "60s"
+	defaultIngressSettings            = "ALLOW_ALL"
+	defaultVpcConnectorEgressSettings = "PRIVATE_RANGES_ONLY"
+)
+
+// functionField is a field of a cloudfunctions.CloudFunction that can be
+// updated using the update mask of a Patch call.
+type functionField struct {
+	// path is the name of the field as expected by the update mask.
+	path string
+
+	// values flattens the field into a set of key/value pairs used to
+	// compare and display it. Scalar fields use an empty key.
+	values func(cf *cloudfunctions.CloudFunction) map[string]string
+
+	// equal reports whether the field has the same value in both functions.
+	// If nil, the flattened values are compared.
+	equal func(current, desired *cloudfunctions.CloudFunction) bool
+
+	// sensitive is set for fields whose values may hold credentials and
+	// are not displayed.
+	sensitive bool
+}
+
+// same reports whether the field has the same value in both functions.
+func (f functionField) same(current, desired *cloudfunctions.CloudFunction) bool {
+	if f.equal != nil {
+		return f.equal(current, desired)
+	}
+
+	return equalMaps(f.values(current), f.values(desired))
+}
+
+// updatableFields lists the fields managed by DeployConfig which are
+// compared with the live function on update.
+var updatableFields = []functionField{
+	intField("availableMemoryMb", func(cf *cloudfunctions.CloudFunction) int64 {
+		return orDefaultInt(cf.AvailableMemoryMb, defaultAvailableMemoryMB)
+	}),
+	sensitiveMapField("buildEnvironmentVariables", func(cf *cloudfunctions.CloudFunction) map[string]string {
+		return cf.BuildEnvironmentVariables
+	}),
+	stringField("description", func(cf *cloudfunctions.CloudFunction) string { return cf.Description }),
+	stringField("entryPoint", func(cf *cloudfunctions.CloudFunction) string { return cf.EntryPoint }),
+	sensitiveMapField("environmentVariables", func(cf *cloudfunctions.CloudFunction) map[string]string {
+		return cf.EnvironmentVariables
+	}),
+	{
+		path: "eventTrigger",
+		values: func(cf *cloudfunctions.CloudFunction) map[string]string {
+			t := cf.EventTrigger
+			if t == nil {
+				return nil
+			}
+
+			return map[string]string{
+				"eventType": t.EventType,
+				"resource":  t.Resource,
+				"service":   t.Service,
+				"retry":     strconv.FormatBool(t.FailurePolicy != nil && t.FailurePolicy.Retry != nil),
+			}
+		},
+		equal: func(c, d *cloudfunctions.CloudFunction) bool {
+			return equalEventTriggers(c.EventTrigger, d.EventTrigger)
+		},
+	},
+	{
+		// The URL and security level are set by Google Cloud Functions,
+		// only the presence of the trigger is configurable.
+		path: "httpsTrigger",
+		values: func(cf *cloudfunctions.CloudFunction) map[string]string {
+			if cf.HttpsTrigger == nil {
+				return nil
+			}
+
+			return map[string]string{"": "enabled"}
+		},
+	},
+	stringField("ingressSettings", func(cf *cloudfunctions.CloudFunction) string {
+		return orDefault(cf.IngressSettings, defaultIngressSettings)
+	}),
+	mapField("labels", func(cf *cloudfunctions.CloudFunction) map[string]string { return cf.Labels }),
+	intField("maxInstances", func(cf *cloudfunctions.CloudFunction) int64 { return cf.MaxInstances }),
+	stringField("network", func(cf *cloudfunctions.CloudFunction) string { return cf.Network }),
+	stringField("runtime", func(cf *cloudfunctions.CloudFunction) string { return cf.Runtime }),
+	{
+		path:   "secretEnvironmentVariables",
+		values: secretEnvVarsValues,
+		equal: func(c, d *cloudfunctions.CloudFunction) bool {
+			return equalSecretRefs(secretEnvVarsValues(c), secretEnvVarsValues(d))
+		},
+	},
+	{
+		path:   "secretVolumes",
+		values: secretVolumesValues,
+		equal: func(c, d *cloudfunctions.CloudFunction) bool {
+			return equalSecretRefs(secretVolumesValues(c), secretVolumesValues(d))
+		},
+	},
+	optionalStringField("serviceAccountEmail", func(cf *cloudfunctions.CloudFunction) string {
+		return cf.ServiceAccountEmail
+	}),
+	optionalStringField("buildServiceAccount", func(cf *cloudfunctions.CloudFunction) string {
+		return cf.BuildServiceAccount
+	}),
+	stringField("timeout", func(cf *cloudfunctions.CloudFunction) string {
+		return orDefault(cf.Timeout, defaultTimeout)
+	}),
+	stringField("vpcConnector", func(cf *cloudfunctions.CloudFunction) string { return cf.VpcConnector }),
+	{
+		path: "vpcConnectorEgressSettings",
+		values: func(cf *cloudfunctions.CloudFunction) map[string]string {
+			// The egress settings are only relevant when a connector is used.
+			if cf.VpcConnector == "" {
+				return nil
+			}
+
+			return map[string]string{
+				"": orDefault(cf.VpcConnectorEgressSettings, defaultVpcConnectorEgressSettings),
+			}
+		},
+	},
+}
+
+// updateMask returns the list of fields that differ between the live function
+// and the desired one. The source of the function is always part of the mask
+// since every deployment uploads a new archive.
+func updateMask(current, desired *cloudfunctions.CloudFunction) []string {
+	// The source is always updated, so that a new version is deployed.
+	mask := sourceMask(current, desired)
+
+	for _, f := range updatableFields {
+		if !f.same(current, desired) {
+			mask = append(mask, f.path)
+		}
+	}
+
+	return mask
+}
+
+// sourceMask returns the update mask of the source of the function. When the
+// source moves to another field, e.g. from sourceUploadUrl to
+// sourceArchiveUrl, the field of the live function is part of the mask so
+// that it is cleared.
+func sourceMask(current, desired *cloudfunctions.CloudFunction) []string {
+	mask := []string{sourceField(desired)}
+	if current != nil && sourceField(current) != mask[0] {
+		mask = append(mask, sourceField(current))
+	}
+
+	return mask
+}
+
+// sourceField returns the path of the field holding the source of the
+// function.
+func sourceField(cf *cloudfunctions.CloudFunction) string {
+	switch {
+	case cf.SourceArchiveUrl != "":
+		return "sourceArchiveUrl"
+	case cf.SourceRepository != nil:
+		return "sourceRepository"
+	default:
+		return "sourceUploadUrl"
+	}
+}
+
+func stringField(path string, get func(cf *cloudfunctions.CloudFunction) string) functionField {
+	return functionField{
+		path: path,
+		values: func(cf *cloudfunctions.CloudFunction) map[string]string {
+			v := get(cf)
+			if v == "" {
+				return nil
+			}
+
+			return map[string]string{"": v}
+		},
+	}
+}
+
+// optionalStringField is a stringField whose default value is chosen by
+// Google Cloud Functions. The field is left unchanged when not configured:
+// the live function always reports a value, so removing the field from the
+// configuration keeps the value previously deployed instead of resetting it
+// to the default.
+func optionalStringField(path string, get func(cf *cloudfunctions.CloudFunction) string) functionField {
+	f := stringField(path, get)
+	f.equal = func(current, desired *cloudfunctions.CloudFunction) bool {
+		return get(desired) == "" || get(current) == get(desired)
+	}
+
+	return f
+}
+
+func intField(path string, get func(cf *cloudfunctions.CloudFunction) int64) functionField {
+	return functionField{
+		path: path,
+		values: func(cf *cloudfunctions.CloudFunction) map[string]string {
+			v := get(cf)
+			if v == 0 {
+				return nil
+			}
+
+			return map[string]string{"": strconv.FormatInt(v, 10)}
+		},
+	}
+}
+
+func mapField(path string, get func(cf *cloudfunctions.CloudFunction) map[string]string) functionField {
+	return functionField{path: path, values: get}
+}
+
+// sensitiveMapField is a mapField whose values are not displayed, e.g.
+// environment variables.
+func sensitiveMapField(path string, get func(cf *cloudfunctions.CloudFunction) map[string]string) functionField {
+	f := mapField(path, get)
+	f.sensitive = true
+
+	return f
+}
+
+// equalEventTriggers compares the configurable parts of two event triggers.
+func equalEventTriggers(current, desired *cloudfunctions.EventTrigger) bool {
+	if current == nil || desired == nil {
+		return current == desired
+	}
+
+	if current.EventType != desired.EventType || !equalResources(current.Resource, desired.Resource) {
+		return false
+	}
+
+	// The service is normalized by Google Cloud Functions when left empty.
+	if desired.Service != "" && current.Service != desired.Service {
+		return false
+	}
+
+	return (current.FailurePolicy != nil && current.FailurePolicy.Retry != nil) ==
+		(desired.FailurePolicy != nil && desired.FailurePolicy.Retry != nil)
+}
+
+// equalResources compares the resource of an event trigger. Short names, such
+// as the name of a topic or a bucket, are accepted on deploy but the live
+// function always reports the full resource name, e.g.
+// `projects/{project}/topics/{topic}`.
+func equalResources(current, desired string) bool {
+	return current == desired || (desired != "" && strings.HasSuffix(current, "/"+desired))
+}
+
+// equalMaps compares two maps, considering nil and empty maps as equal.
+func equalMaps(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+
+	return v
+}
+
+func orDefaultInt(v, def int64) int64 {
+	if v == 0 {
+		return def
+	}
+
+	return v
+}
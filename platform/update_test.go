@@ -0,0 +1,254 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/cloudfunctions/v1"
+)
+
+func TestUpdateMask(t *testing.T) {
+	cases := []struct {
+		name    string
+		current *cloudfunctions.CloudFunction
+		desired *cloudfunctions.CloudFunction
+		want    []string
+	}{
+		{
+			name:    "unchanged",
+			current: &cloudfunctions.CloudFunction{Runtime: "go113", EntryPoint: "Hello"},
+			desired: &cloudfunctions.CloudFunction{Runtime: "go113", EntryPoint: "Hello"},
+			want:    []string{"sourceUploadUrl"},
+		},
+		{
+			name:    "unset memory with the default live value",
+			current: &cloudfunctions.CloudFunction{AvailableMemoryMb: defaultAvailableMemoryMB},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    []string{"sourceUploadUrl"},
+		},
+		{
+			name:    "unset memory with a custom live value",
+			current: &cloudfunctions.CloudFunction{AvailableMemoryMb: 512},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    []string{"sourceUploadUrl", "availableMemoryMb"},
+		},
+		{
+			name: "removed environment variable",
+			current: &cloudfunctions.CloudFunction{
+				EnvironmentVariables: map[string]string{"A": "1", "B": "2"},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EnvironmentVariables: map[string]string{"A": "1"},
+			},
+			want: []string{"sourceUploadUrl", "environmentVariables"},
+		},
+		{
+			name:    "nil and empty environment variables",
+			current: &cloudfunctions.CloudFunction{},
+			desired: &cloudfunctions.CloudFunction{EnvironmentVariables: map[string]string{}},
+			want:    []string{"sourceUploadUrl"},
+		},
+		{
+			name: "http trigger switched to an event trigger",
+			current: &cloudfunctions.CloudFunction{
+				HttpsTrigger: &cloudfunctions.HttpsTrigger{Url: "https://example.com"},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/t",
+				},
+			},
+			want: []string{"sourceUploadUrl", "eventTrigger", "httpsTrigger"},
+		},
+		{
+			name: "event trigger with a normalized service",
+			current: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/t",
+					Service:   "pubsub.googleapis.com",
+				},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/t",
+				},
+			},
+			want: []string{"sourceUploadUrl"},
+		},
+		{
+			name: "event trigger with a short resource name",
+			current: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/t",
+				},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "t",
+				},
+			},
+			want: []string{"sourceUploadUrl"},
+		},
+		{
+			name: "event trigger with another resource",
+			current: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/at",
+				},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "t",
+				},
+			},
+			want: []string{"sourceUploadUrl", "eventTrigger"},
+		},
+		{
+			name:    "max instances reset",
+			current: &cloudfunctions.CloudFunction{MaxInstances: 10},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    []string{"sourceUploadUrl", "maxInstances"},
+		},
+		{
+			name:    "service account left unset",
+			current: &cloudfunctions.CloudFunction{ServiceAccountEmail: "fn@p.iam.gserviceaccount.com"},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    []string{"sourceUploadUrl"},
+		},
+		{
+			name:    "service account changed",
+			current: &cloudfunctions.CloudFunction{ServiceAccountEmail: "fn@p.iam.gserviceaccount.com"},
+			desired: &cloudfunctions.CloudFunction{ServiceAccountEmail: "other@p.iam.gserviceaccount.com"},
+			want:    []string{"sourceUploadUrl", "serviceAccountEmail"},
+		},
+		{
+			name:    "service account removed",
+			current: &cloudfunctions.CloudFunction{ServiceAccountEmail: "fn@p.iam.gserviceaccount.com"},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    []string{"sourceUploadUrl"},
+		},
+		{
+			name:    "archive source",
+			current: &cloudfunctions.CloudFunction{SourceArchiveUrl: "gs://b/a.zip"},
+			desired: &cloudfunctions.CloudFunction{SourceArchiveUrl: "gs://b/b.zip"},
+			want:    []string{"sourceArchiveUrl"},
+		},
+		{
+			name:    "upload source moved to an archive",
+			current: &cloudfunctions.CloudFunction{SourceUploadUrl: "https://storage.googleapis.com/upload"},
+			desired: &cloudfunctions.CloudFunction{SourceArchiveUrl: "gs://b/a.zip"},
+			want:    []string{"sourceArchiveUrl", "sourceUploadUrl"},
+		},
+		{
+			name:    "archive source moved to an upload",
+			current: &cloudfunctions.CloudFunction{SourceArchiveUrl: "gs://b/a.zip"},
+			desired: &cloudfunctions.CloudFunction{SourceUploadUrl: "https://storage.googleapis.com/upload"},
+			want:    []string{"sourceUploadUrl", "sourceArchiveUrl"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := updateMask(tc.current, tc.desired)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("updateMask() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffFunction(t *testing.T) {
+	cases := []struct {
+		name    string
+		current *cloudfunctions.CloudFunction
+		desired *cloudfunctions.CloudFunction
+		want    []fieldChange
+	}{
+		{
+			name:    "new function",
+			current: nil,
+			desired: &cloudfunctions.CloudFunction{Runtime: "go113"},
+			want: []fieldChange{
+				{Field: "runtime", Action: changeAdded, Desired: "go113"},
+			},
+		},
+		{
+			name:    "unset memory with the default live value",
+			current: &cloudfunctions.CloudFunction{AvailableMemoryMb: defaultAvailableMemoryMB},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    nil,
+		},
+		{
+			name: "removed environment variable",
+			current: &cloudfunctions.CloudFunction{
+				EnvironmentVariables: map[string]string{"A": "1", "B": "2"},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EnvironmentVariables: map[string]string{"A": "1"},
+			},
+			want: []fieldChange{
+				{Field: "environmentVariables.B", Action: changeRemoved, Current: "2", Sensitive: true},
+			},
+		},
+		{
+			name: "http trigger switched to an event trigger",
+			current: &cloudfunctions.CloudFunction{
+				HttpsTrigger: &cloudfunctions.HttpsTrigger{Url: "https://example.com"},
+			},
+			desired: &cloudfunctions.CloudFunction{
+				EventTrigger: &cloudfunctions.EventTrigger{
+					EventType: "google.pubsub.topic.publish",
+					Resource:  "projects/p/topics/t",
+				},
+			},
+			want: []fieldChange{
+				{Field: "eventTrigger.eventType", Action: changeAdded, Desired: "google.pubsub.topic.publish"},
+				{Field: "eventTrigger.resource", Action: changeAdded, Desired: "projects/p/topics/t"},
+				{Field: "eventTrigger.retry", Action: changeAdded, Desired: "false"},
+				{Field: "eventTrigger.service", Action: changeAdded, Desired: ""},
+				{Field: "httpsTrigger", Action: changeRemoved, Current: "enabled"},
+			},
+		},
+		{
+			name:    "max instances reset",
+			current: &cloudfunctions.CloudFunction{MaxInstances: 10},
+			desired: &cloudfunctions.CloudFunction{},
+			want: []fieldChange{
+				{Field: "maxInstances", Action: changeRemoved, Current: "10"},
+			},
+		},
+		{
+			name:    "service account left unset",
+			current: &cloudfunctions.CloudFunction{ServiceAccountEmail: "fn@p.iam.gserviceaccount.com"},
+			desired: &cloudfunctions.CloudFunction{},
+			want:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffFunction(tc.current, tc.desired)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("diffFunction() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPromoteMask(t *testing.T) {
+	current := &cloudfunctions.CloudFunction{SourceUploadUrl: "https://storage.googleapis.com/upload"}
+	desired := &cloudfunctions.CloudFunction{SourceArchiveUrl: "gs://b/a.zip"}
+
+	mask := promoteMask(current, desired)
+
+	if len(mask) != len(updatableFields)+2 || mask[0] != "sourceArchiveUrl" || mask[1] != "sourceUploadUrl" {
+		t.Errorf("promoteMask() = %v", mask)
+	}
+}
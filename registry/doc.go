@@ -19,5 +19,25 @@ func (r *Registry) Documentation() (*docs.Documentation, error) {
 		`Location represents the Google Cloud location where the application will be deployed, e.g. us-west1.`,
 	)
 
+	_ = doc.SetField(
+		"generation",
+		`Generation of Google Cloud Functions to deploy to, either 1 or 2.
+2nd gen functions are deployed using the Cloud Functions v2 API. Defaults to 1.`,
+	)
+
+	_ = doc.SetField(
+		"bucket",
+		`Cloud Storage bucket where the archives are stored, under 'waypoint/{project}/{location}/{sha256}.zip',
+and deployed from. Unlike the signed upload URLs used by default, which can only be used once, the stored
+archives can be deployed again later. The upload is skipped when the archive is already stored. The Cloud
+Functions service agent must be able to read the bucket.`,
+	)
+
+	_ = doc.SetField(
+		"workspace",
+		`Overrides the project and location for a Waypoint workspace, e.g. 'workspace "staging" { project = "..." }',
+so that the functions of each workspace are separate resources.`,
+	)
+
 	return doc, nil
 }
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+)
+
+// progressInterval is the minimum delay between two updates of the status.
+const progressInterval = 500 * time.Millisecond
+
+// uploadProgress reports the progress of an upload to a terminal.Status.
+type uploadProgress struct {
+	st    terminal.Status
+	total int64
+
+	start      time.Time
+	lastUpdate time.Time
+}
+
+func newUploadProgress(st terminal.Status, total int64) *uploadProgress {
+	return &uploadProgress{st: st, total: total, start: time.Now()}
+}
+
+// restart resets the progress, e.g. when an upload is retried.
+func (p *uploadProgress) restart() {
+	p.start = time.Now()
+	p.lastUpdate = time.Time{}
+}
+
+// update reports that sent bytes were uploaded.
+func (p *uploadProgress) update(sent int64) {
+	now := time.Now()
+	if sent < p.total && now.Sub(p.lastUpdate) < progressInterval {
+		return
+	}
+	p.lastUpdate = now
+
+	msg := fmt.Sprintf("Uploading archive: %s / %s", formatBytes(sent), formatBytes(p.total))
+	if p.total > 0 {
+		msg += fmt.Sprintf(" (%d%%)", sent*100/p.total)
+	}
+
+	if elapsed := now.Sub(p.start); elapsed > 0 && sent > 0 {
+		rate := float64(sent) / elapsed.Seconds()
+		msg += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+
+		if remaining := p.total - sent; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			msg += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+
+	p.st.Update(msg)
+}
+
+// logUpload logs the size and duration of the last attempt of a finished
+// upload.
+func logUpload(log hclog.Logger, p *uploadProgress, destination string) {
+	duration := time.Since(p.start)
+
+	var throughput int64
+	if duration > 0 {
+		throughput = int64(float64(p.total) / duration.Seconds())
+	}
+
+	log.Info("archive uploaded",
+		"destination", destination,
+		"size", formatBytes(p.total),
+		"duration", duration.Round(time.Millisecond),
+		"throughput", formatBytes(throughput)+"/s",
+	)
+}
+
+// reader returns r reporting the bytes read from it as uploaded.
+func (p *uploadProgress) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, progress: p}
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress *uploadProgress
+	read     int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.read += int64(n)
+	r.progress.update(r.read)
+
+	return n, err
+}
+
+// formatBytes formats a number of bytes for humans, e.g. "12.3 MB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
 	"os"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/waypoint-plugin-sdk/component"
 	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
 	"github.com/sharkyze/waypoint-plugin-archive/builder"
 	"google.golang.org/api/cloudfunctions/v1"
+	cloudfunctionsv2 "google.golang.org/api/cloudfunctions/v2"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
 )
 
 type RegistryConfig struct {
@@ -21,6 +24,57 @@ type RegistryConfig struct {
 	// Location	represents the Google Cloud location where the application
 	// will be deployed, e.g. us-west1.
 	Location string `hcl:"location,attr"`
+
+	// Generation of Google Cloud Functions to deploy to, either 1 or 2.
+	// 2nd gen functions are deployed using the Cloud Functions v2 API.
+	// Defaults to 1.
+	Generation int64 `hcl:"generation,optional"`
+
+	// Bucket, if set, is the Cloud Storage bucket where the archives are
+	// stored, under an object named after the SHA-256 digest of their
+	// content. Unlike the default signed upload URLs, which can only be
+	// used once, the stored archives can be deployed again later.
+	Bucket string `hcl:"bucket,optional"`
+
+	// Workspaces overrides the project and location for Waypoint
+	// workspaces, so that each workspace deploys its own function.
+	Workspaces []workspaceConfig `hcl:"workspace,block"`
+}
+
+// workspaceConfig overrides the configuration for a Waypoint workspace.
+type workspaceConfig struct {
+	// Workspace is the name of the Waypoint workspace.
+	Workspace string `hcl:"workspace,label"`
+
+	// Project to deploy to in the workspace.
+	Project string `hcl:"project,optional"`
+
+	// Location to deploy to in the workspace.
+	Location string `hcl:"location,optional"`
+}
+
+// forWorkspace returns the configuration with the overrides of the
+// workspace of the job applied.
+func (c RegistryConfig) forWorkspace(job *component.JobInfo) RegistryConfig {
+	if job == nil {
+		return c
+	}
+
+	for _, w := range c.Workspaces {
+		if w.Workspace != job.Workspace {
+			continue
+		}
+
+		if w.Project != "" {
+			c.Project = w.Project
+		}
+
+		if w.Location != "" {
+			c.Location = w.Location
+		}
+	}
+
+	return c
 }
 
 type Registry struct {
@@ -41,7 +95,21 @@ func (r *Registry) ConfigSet(config interface{}) error {
 	}
 
 	// validate the config
-	_ = c
+	switch c.Generation {
+	case 0:
+		c.Generation = 1
+	case 1, 2:
+	default:
+		return fmt.Errorf("generation must be either 1 or 2, got %d", c.Generation)
+	}
+
+	workspaces := map[string]bool{}
+	for _, w := range c.Workspaces {
+		if workspaces[w.Workspace] {
+			return fmt.Errorf("workspace %q is configured more than once", w.Workspace)
+		}
+		workspaces[w.Workspace] = true
+	}
 
 	return nil
 }
@@ -81,6 +149,7 @@ func (r *Registry) PushFunc() interface{} {
 func (r *Registry) push(
 	ctx context.Context,
 	log hclog.Logger,
+	job *component.JobInfo,
 	ui terminal.UI,
 	archive *builder.Archive,
 ) (*Artifact, error) {
@@ -89,68 +158,131 @@ func (r *Registry) push(
 
 	st.Update("Pushing archive to Google Cloud Storage")
 
+	config := r.config.forWorkspace(job)
+
 	artifact := Artifact{
-		Project:  r.config.Project,
-		Location: r.config.Location,
+		Project:    config.Project,
+		Location:   config.Location,
+		Generation: config.Generation,
 	}
 
-	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	file, err := os.Open(archive.OutputPath)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	uploadURLCall := cloudfunctionsService.Projects.Locations.Functions.GenerateUploadUrl(
-		fmt.Sprintf("projects/%s/locations/%s", r.config.Project, r.config.Location),
-		&cloudfunctions.GenerateUploadUrlRequest{},
-	)
-	uploadURLCall = uploadURLCall.Context(ctx)
-
-	uploadURLResponse, err := uploadURLCall.Do()
+	fi, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	uploadURL := uploadURLResponse.UploadUrl
-	artifact.Source = uploadURL
+	if fi.Size() > cloudfunctionsutil.MaxArchiveSize {
+		return nil, errors.New("File size should not exceed 100MiB")
+	}
 
-	file, err := os.Open(archive.OutputPath)
+	hashes, err := cloudfunctionsutil.HashFile(file)
 	if err != nil {
 		return nil, err
 	}
 
-	fi, err := file.Stat()
+	artifact.Digest = hashes.SHA256
+
+	progress := newUploadProgress(st, fi.Size())
+
+	if config.Bucket != "" {
+		uploaded, err := uploadToBucket(ctx, config.Bucket, progress, &artifact, file, hashes)
+		if err != nil {
+			return nil, err
+		}
+
+		if uploaded {
+			logUpload(log, progress, artifact.Source)
+			st.Step(terminal.StatusOK, "Cloud Function Archive successfully uploaded to '"+artifact.Source+"'")
+		} else {
+			st.Step(terminal.StatusOK, "Cloud Function Archive already stored in '"+artifact.Source+"', skipping upload")
+		}
+
+		return &artifact, nil
+	}
+
+	var uploadURL string
+
+	if config.Generation == 2 {
+		uploadURL, err = generateUploadURLV2(ctx, &artifact)
+	} else {
+		uploadURL, err = generateUploadURL(ctx, &artifact)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	mb100 := int64(1e+8)
-	if fi.Size() > mb100 {
-		return nil, errors.New("File size should not exceed 100MB")
+	err = cloudfunctionsutil.UploadToURL(ctx, uploadURL, file, fi.Size(), hashes, cloudfunctionsutil.UploadOptions{
+		Log: log,
+		Attempt: func(r io.Reader) io.Reader {
+			progress.restart()
+			return progress.reader(r)
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, file)
+	logUpload(log, progress, "signed upload URL")
+
+	st.Step(terminal.StatusOK, "Cloud Function Archive successfully uploaded to Google Cloud Functions")
+
+	return &artifact, nil
+}
+
+// generateUploadURL returns a signed URL to upload the archive of a 1st gen
+// function to. The artifact source is set to the URL.
+func generateUploadURL(ctx context.Context, artifact *Artifact) (string, error) {
+	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	req.Header.Add("content-type", "application/zip")
-	req.Header.Add("x-goog-content-length-range", "0,104857600")
+	uploadURLCall := cloudfunctionsService.Projects.Locations.Functions.GenerateUploadUrl(
+		fmt.Sprintf("projects/%s/locations/%s", artifact.Project, artifact.Location),
+		&cloudfunctions.GenerateUploadUrlRequest{},
+	)
+	uploadURLCall = uploadURLCall.Context(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	uploadURLResponse, err := uploadURLCall.Do()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	artifact.Source = uploadURLResponse.UploadUrl
+
+	return uploadURLResponse.UploadUrl, nil
+}
+
+// generateUploadURLV2 returns a signed URL to upload the archive of a 2nd gen
+// function to. The storage source of the artifact is set to the object the
+// archive is uploaded to.
+func generateUploadURLV2(ctx context.Context, artifact *Artifact) (string, error) {
+	cloudfunctionsService, err := cloudfunctionsv2.NewService(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if resp.StatusCode > 299 {
-		return nil, errors.New(resp.Status + "\n\n" + string(body))
+	uploadURLCall := cloudfunctionsService.Projects.Locations.Functions.GenerateUploadUrl(
+		fmt.Sprintf("projects/%s/locations/%s", artifact.Project, artifact.Location),
+		&cloudfunctionsv2.GenerateUploadUrlRequest{},
+	)
+	uploadURLCall = uploadURLCall.Context(ctx)
+
+	uploadURLResponse, err := uploadURLCall.Do()
+	if err != nil {
+		return "", err
 	}
 
-	st.Step(terminal.StatusOK, "Cloud Function Archive successfully uploaded to Google Cloud Functions")
+	artifact.Source = uploadURLResponse.UploadUrl
+	if s := uploadURLResponse.StorageSource; s != nil {
+		artifact.StorageSource = &StorageSource{Bucket: s.Bucket, Object: s.Object}
+	}
 
-	return &artifact, nil
+	return uploadURLResponse.UploadUrl, nil
 }
@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/internal/cloudfunctionsutil"
+)
+
+// archiveObject returns the name of the object storing the archive with the
+// given digest in the bucket. Archives are addressed by their content, so
+// that the object of an artifact is never overwritten by another archive
+// and can be deployed again later.
+func archiveObject(project, location, digest string) string {
+	return fmt.Sprintf("waypoint/%s/%s/%s.zip", project, location, digest)
+}
+
+// digestMetadata is the custom metadata of the stored archives holding their
+// digest, to check the content of existing objects.
+const digestMetadata = "sha256"
+
+// uploadToBucket stores the archive in the bucket unless an archive with the
+// same digest is already stored for the project and location, and sets the
+// source of the artifact to the stored object. It reports whether the archive
+// was uploaded.
+func uploadToBucket(
+	ctx context.Context,
+	bucket string,
+	progress *uploadProgress,
+	artifact *Artifact,
+	file *os.File,
+	hashes cloudfunctionsutil.ArchiveHashes,
+) (bool, error) {
+	storageService, err := storage.NewService(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	name := archiveObject(artifact.Project, artifact.Location, artifact.Digest)
+
+	object, err := storageService.Objects.Get(bucket, name).Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+			return false, err
+		}
+	}
+
+	uploaded := false
+	if object == nil || object.Metadata[digestMetadata] != artifact.Digest {
+		// Cloud Storage rejects the upload if the content doesn't match the
+		// hashes. Large archives are uploaded in chunks, each retried on
+		// transient errors.
+		object = &storage.Object{
+			Name:        name,
+			ContentType: cloudfunctionsutil.ArchiveContentType,
+			Metadata:    map[string]string{digestMetadata: artifact.Digest},
+			Md5Hash:     hashes.MD5,
+			Crc32c:      hashes.CRC32C,
+		}
+
+		object, err = storageService.Objects.Insert(bucket, object).
+			Media(
+				file,
+				googleapi.ContentType(cloudfunctionsutil.ArchiveContentType),
+				googleapi.ChunkRetryDeadline(cloudfunctionsutil.UploadAttemptTimeout),
+			).
+			ProgressUpdater(func(current, _ int64) { progress.update(current) }).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return false, err
+		}
+
+		uploaded = true
+	}
+
+	artifact.Source = fmt.Sprintf("gs://%s/%s", object.Bucket, object.Name)
+	if artifact.Generation == 2 {
+		artifact.StorageSource = &StorageSource{
+			Bucket:     object.Bucket,
+			Object:     object.Name,
+			Generation: object.Generation,
+		}
+	}
+
+	return uploaded, nil
+}
@@ -2,8 +2,14 @@ package release
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 
 	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
+	"google.golang.org/api/googleapi"
+
+	"github.com/sharkyze/waypoint-plugin-cloudfunctions/platform"
 )
 
 // Implement the Destroyer interface
@@ -39,5 +45,82 @@ func (rm *ReleaseManager) DestroyFunc() interface{} {
 // If an error is returned, Waypoint stops the execution flow and
 // returns an error to the user.
 func (rm *ReleaseManager) destroy(ctx context.Context, ui terminal.UI, release *Release) error {
+	st := ui.Status()
+	defer st.Close()
+
+	if len(release.AddedBindings) == 0 {
+		st.Step(terminal.StatusOK, "No Operation destroy, the release didn't change the IAM Policy")
+		return nil
+	}
+
+	st.Update("Removing the IAM Policy bindings added by the release")
+
+	// The bindings are kept once another deployment updated the function,
+	// its release may rely on them: releases only record the members they
+	// added, not the ones which were already granted.
+	current, err := platform.IsCurrentDeployment(ctx, &platform.Deployment{
+		Name:         release.Name,
+		Version:      release.Version,
+		Generation:   release.Generation,
+		Revision:     release.Revision,
+		DeploymentId: release.DeploymentId,
+	})
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			st.Step(terminal.StatusOK, "Google Cloud Function already deleted, nothing to revoke")
+			return nil
+		}
+
+		st.Step(terminal.StatusError, "Error fetching function")
+		return err
+	}
+
+	if !current {
+		st.Step(
+			terminal.StatusWarn,
+			"IAM Policy bindings added by the release kept, the function was updated by another deployment",
+		)
+		return nil
+	}
+
+	res, err := newIAMResource(ctx, release)
+	if err != nil {
+		st.Step(terminal.StatusError, "Error updating IAM Policy")
+		return err
+	}
+
+	var revoked []*iamBinding
+
+	err = updatePolicy(ctx, res, func(policy *iamPolicy) bool {
+		revoked = nil
+
+		for _, b := range release.AddedBindings {
+			if removed := policy.removeMembers(b.Role, b.Members...); len(removed) > 0 {
+				revoked = append(revoked, &iamBinding{Role: b.Role, Members: removed})
+			}
+		}
+
+		return len(revoked) > 0
+	})
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			st.Step(terminal.StatusOK, "Google Cloud Function already deleted, nothing to revoke")
+			return nil
+		}
+
+		st.Step(terminal.StatusError, "Error updating IAM Policy")
+		return err
+	}
+
+	for _, b := range revoked {
+		st.Step(terminal.StatusOK, fmt.Sprintf("IAM Policy updated, revoked '%s' from %s", b.Role, quoteAll(b.Members)))
+	}
+
+	if len(revoked) == 0 {
+		st.Step(terminal.StatusOK, "IAM Policy bindings added by the release were already removed")
+	}
+
 	return nil
 }
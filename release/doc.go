@@ -10,11 +10,28 @@ func (rm *ReleaseManager) Documentation() (*docs.Documentation, error) {
 		return nil, err
 	}
 
-	doc.Description("If the function is unauthenticated, set the required IAM Policies. Otherwise a No-operation.")
+	doc.Description(
+		"Grant or revoke public access to the function by merging the invoker bindings into its IAM Policy.",
+	)
 
 	_ = doc.SetField(
 		"unauthenticated",
-		`If set to true, will allow unauthenticated access to your deployment. This defaults to false.`,
+		`If set to true, will allow unauthenticated access to your deployment. This defaults to false.
+If set to false, public access previously granted to 'allUsers' or 'allAuthenticatedUsers' is revoked.`,
+	)
+
+	_ = doc.SetField(
+		"invokers",
+		`Members allowed to invoke the function without making it public, e.g.
+"serviceAccount:scheduler@project-id.iam.gserviceaccount.com", "group:team@example.com",
+"domain:example.com" or "allAuthenticatedUsers".`,
+	)
+
+	_ = doc.SetField(
+		"binding",
+		`Additional role bindings to set on the function. Each block takes a 'role', e.g.
+"roles/cloudfunctions.viewer", and the 'members' granted the role.
+For 2nd gen functions, the roles are granted on the Cloud Run service of the function.`,
 	)
 
 	return doc, nil
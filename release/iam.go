@@ -0,0 +1,341 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudfunctions/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/run/v1"
+)
+
+// Members granting access to anyone on the internet.
+const (
+	allUsers              = "allUsers"
+	allAuthenticatedUsers = "allAuthenticatedUsers"
+)
+
+// maxPolicyUpdateAttempts is the number of times a policy update is attempted
+// when the policy is concurrently modified.
+const maxPolicyUpdateAttempts = 5
+
+// conditionalPolicyVersion is the IAM policy version supporting conditional
+// role bindings. It is requested so that conditional bindings are preserved.
+const conditionalPolicyVersion = 3
+
+// iamPolicy is an IAM policy independent of the API used to read and write it.
+type iamPolicy struct {
+	Bindings []*iamBinding
+	Etag     string
+	Version  int64
+}
+
+// iamBinding associates members with a role, optionally under a condition.
+type iamBinding struct {
+	Role      string
+	Members   []string
+	Condition *iamCondition
+}
+
+type iamCondition struct {
+	Description string
+	Expression  string
+	Location    string
+	Title       string
+}
+
+// addMembers grants role to the members through the unconditional binding of
+// the role, creating it if needed. It returns the members which were added.
+func (p *iamPolicy) addMembers(role string, members ...string) []string {
+	if len(members) == 0 {
+		return nil
+	}
+
+	var b *iamBinding
+	for _, candidate := range p.Bindings {
+		if candidate.Role == role && candidate.Condition == nil {
+			b = candidate
+			break
+		}
+	}
+
+	if b == nil {
+		b = &iamBinding{Role: role}
+		p.Bindings = append(p.Bindings, b)
+	}
+
+	var added []string
+
+	for _, m := range members {
+		if !contains(b.Members, m) {
+			b.Members = append(b.Members, m)
+			added = append(added, m)
+		}
+	}
+
+	return added
+}
+
+// removeMembers revokes role from the members in the unconditional bindings of
+// the role. It returns the members which were removed.
+func (p *iamPolicy) removeMembers(role string, members ...string) []string {
+	var removed []string
+
+	for _, b := range p.Bindings {
+		if b.Role != role || b.Condition != nil {
+			continue
+		}
+
+		kept := b.Members[:0]
+		for _, m := range b.Members {
+			if contains(members, m) {
+				removed = append(removed, m)
+			} else {
+				kept = append(kept, m)
+			}
+		}
+
+		b.Members = kept
+	}
+
+	return removed
+}
+
+// iamResource is a resource whose IAM policy controls who can invoke a
+// deployed function.
+type iamResource interface {
+	// invokerRole is the role allowing to invoke the function.
+	invokerRole() string
+
+	getPolicy(ctx context.Context) (*iamPolicy, error)
+	setPolicy(ctx context.Context, policy *iamPolicy) error
+}
+
+// newIAMResource returns the resource holding the invoker permission of the
+// released function: the function itself for 1st gen functions, and the Cloud
+// Run service serving the function for 2nd gen functions.
+func newIAMResource(ctx context.Context, release *Release) (iamResource, error) {
+	if release.Generation == 2 {
+		service, err := run.NewService(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &runServiceIAM{service: service, name: release.Service}, nil
+	}
+
+	service, err := cloudfunctions.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &functionIAM{service: service, name: release.Name}, nil
+}
+
+// updatePolicy reads the current policy of the resource, applies update and
+// writes it back. update returns false if it didn't change the policy, in
+// which case nothing is written. The read-modify-write cycle is retried if the
+// policy is concurrently modified.
+func updatePolicy(ctx context.Context, res iamResource, update func(*iamPolicy) bool) error {
+	for attempt := 1; ; attempt++ {
+		policy, err := res.getPolicy(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !update(policy) {
+			return nil
+		}
+
+		err = res.setPolicy(ctx, policy)
+
+		var gerr *googleapi.Error
+		if err == nil || !errors.As(err, &gerr) || gerr.Code != http.StatusConflict {
+			return err
+		}
+
+		if attempt == maxPolicyUpdateAttempts {
+			return fmt.Errorf("IAM policy concurrently modified, giving up after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+// functionIAM is the IAM policy of a 1st gen function.
+type functionIAM struct {
+	service *cloudfunctions.Service
+	name    string
+}
+
+func (f *functionIAM) invokerRole() string { return "roles/cloudfunctions.invoker" }
+
+func (f *functionIAM) getPolicy(ctx context.Context) (*iamPolicy, error) {
+	p, err := f.service.Projects.Locations.Functions.
+		GetIamPolicy(f.name).
+		OptionsRequestedPolicyVersion(conditionalPolicyVersion).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := iamPolicy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		binding := iamBinding{Role: b.Role, Members: b.Members}
+		if c := b.Condition; c != nil {
+			binding.Condition = &iamCondition{
+				Description: c.Description,
+				Expression:  c.Expression,
+				Location:    c.Location,
+				Title:       c.Title,
+			}
+		}
+
+		policy.Bindings = append(policy.Bindings, &binding)
+	}
+
+	return &policy, nil
+}
+
+func (f *functionIAM) setPolicy(ctx context.Context, policy *iamPolicy) error {
+	p := cloudfunctions.Policy{Etag: policy.Etag, Version: policy.Version}
+	for _, b := range policy.Bindings {
+		if len(b.Members) == 0 {
+			continue
+		}
+
+		binding := cloudfunctions.Binding{Role: b.Role, Members: b.Members}
+		if c := b.Condition; c != nil {
+			binding.Condition = &cloudfunctions.Expr{
+				Description: c.Description,
+				Expression:  c.Expression,
+				Location:    c.Location,
+				Title:       c.Title,
+			}
+		}
+
+		p.Bindings = append(p.Bindings, &binding)
+	}
+
+	_, err := f.service.Projects.Locations.Functions.
+		SetIamPolicy(f.name, &cloudfunctions.SetIamPolicyRequest{Policy: &p}).
+		Context(ctx).
+		Do()
+
+	return err
+}
+
+// runServiceIAM is the IAM policy of the Cloud Run service serving a 2nd gen
+// function.
+type runServiceIAM struct {
+	service *run.APIService
+	name    string
+}
+
+func (r *runServiceIAM) invokerRole() string { return "roles/run.invoker" }
+
+func (r *runServiceIAM) getPolicy(ctx context.Context) (*iamPolicy, error) {
+	p, err := r.service.Projects.Locations.Services.
+		GetIamPolicy(r.name).
+		OptionsRequestedPolicyVersion(conditionalPolicyVersion).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := iamPolicy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		binding := iamBinding{Role: b.Role, Members: b.Members}
+		if c := b.Condition; c != nil {
+			binding.Condition = &iamCondition{
+				Description: c.Description,
+				Expression:  c.Expression,
+				Location:    c.Location,
+				Title:       c.Title,
+			}
+		}
+
+		policy.Bindings = append(policy.Bindings, &binding)
+	}
+
+	return &policy, nil
+}
+
+func (r *runServiceIAM) setPolicy(ctx context.Context, policy *iamPolicy) error {
+	p := run.Policy{Etag: policy.Etag, Version: policy.Version}
+	for _, b := range policy.Bindings {
+		if len(b.Members) == 0 {
+			continue
+		}
+
+		binding := run.Binding{Role: b.Role, Members: b.Members}
+		if c := b.Condition; c != nil {
+			binding.Condition = &run.Expr{
+				Description: c.Description,
+				Expression:  c.Expression,
+				Location:    c.Location,
+				Title:       c.Title,
+			}
+		}
+
+		p.Bindings = append(p.Bindings, &binding)
+	}
+
+	_, err := r.service.Projects.Locations.Services.
+		SetIamPolicy(r.name, &run.SetIamPolicyRequest{Policy: &p}).
+		Context(ctx).
+		Do()
+
+	return err
+}
+
+// memberPrefixes are the types of IAM members which can be configured.
+var memberPrefixes = []string{"user:", "serviceAccount:", "group:", "domain:"}
+
+// validateMember checks that m is a valid IAM member.
+func validateMember(m string) error {
+	if m == allUsers || m == allAuthenticatedUsers {
+		return nil
+	}
+
+	for _, prefix := range memberPrefixes {
+		if strings.HasPrefix(m, prefix) && len(m) > len(prefix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"%q must be %q, %q or start with one of %s",
+		m, allUsers, allAuthenticatedUsers, strings.Join(memberPrefixes, ", "),
+	)
+}
+
+// quoteAll formats the values as a comma separated list of quoted values.
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + v + "'"
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,255 @@
+package release
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/cloudfunctions/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const invoker = "roles/cloudfunctions.invoker"
+
+func TestAddMembers(t *testing.T) {
+	conditional := &iamBinding{
+		Role:      invoker,
+		Members:   []string{"user:a@example.com"},
+		Condition: &iamCondition{Title: "expires", Expression: "request.time < timestamp('2030-01-01T00:00:00Z')"},
+	}
+
+	policy := iamPolicy{
+		Bindings: []*iamBinding{
+			conditional,
+			{Role: invoker, Members: []string{"user:b@example.com"}},
+			{Role: "roles/viewer", Members: []string{"user:c@example.com"}},
+		},
+	}
+
+	added := policy.addMembers(invoker, "user:a@example.com", "user:b@example.com", "allUsers")
+
+	if want := []string{"user:a@example.com", "allUsers"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+
+	want := []*iamBinding{
+		conditional,
+		{Role: invoker, Members: []string{"user:b@example.com", "user:a@example.com", "allUsers"}},
+		{Role: "roles/viewer", Members: []string{"user:c@example.com"}},
+	}
+	if !reflect.DeepEqual(policy.Bindings, want) {
+		t.Errorf("bindings = %+v, want %+v", policy.Bindings, want)
+	}
+
+	if want := []string{"user:a@example.com"}; !reflect.DeepEqual(conditional.Members, want) {
+		t.Errorf("conditional binding members = %v, want %v", conditional.Members, want)
+	}
+}
+
+func TestAddMembersCreatesBinding(t *testing.T) {
+	policy := iamPolicy{
+		Bindings: []*iamBinding{
+			{Role: invoker, Members: []string{"user:a@example.com"}, Condition: &iamCondition{Title: "c"}},
+		},
+	}
+
+	added := policy.addMembers(invoker, "allUsers")
+
+	if want := []string{"allUsers"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+
+	if len(policy.Bindings) != 2 {
+		t.Fatalf("got %d bindings, want 2", len(policy.Bindings))
+	}
+
+	if b := policy.Bindings[1]; b.Role != invoker || b.Condition != nil || !reflect.DeepEqual(b.Members, []string{"allUsers"}) {
+		t.Errorf("new binding = %+v", b)
+	}
+
+	if added := policy.addMembers(invoker); added != nil {
+		t.Errorf("added = %v without members", added)
+	}
+}
+
+func TestRemoveMembers(t *testing.T) {
+	conditional := &iamBinding{
+		Role:      invoker,
+		Members:   []string{"allUsers"},
+		Condition: &iamCondition{Title: "c"},
+	}
+
+	policy := iamPolicy{
+		Bindings: []*iamBinding{
+			conditional,
+			{Role: invoker, Members: []string{"allUsers", "user:a@example.com"}},
+			{Role: "roles/viewer", Members: []string{"allUsers"}},
+		},
+	}
+
+	removed := policy.removeMembers(invoker, "allUsers", "user:b@example.com")
+
+	if want := []string{"allUsers"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+
+	want := []*iamBinding{
+		conditional,
+		{Role: invoker, Members: []string{"user:a@example.com"}},
+		{Role: "roles/viewer", Members: []string{"allUsers"}},
+	}
+	if !reflect.DeepEqual(policy.Bindings, want) {
+		t.Errorf("bindings = %+v, want %+v", policy.Bindings, want)
+	}
+}
+
+func TestFunctionIAMSetPolicy(t *testing.T) {
+	var got cloudfunctions.SetIamPolicyRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding the request: %v", err)
+		}
+
+		_ = json.NewEncoder(w).Encode(got.Policy)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	service, err := cloudfunctions.NewService(ctx, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := &functionIAM{service: service, name: "projects/p/locations/l/functions/f"}
+
+	policy := iamPolicy{
+		Etag:    "etag",
+		Version: conditionalPolicyVersion,
+		Bindings: []*iamBinding{
+			{Role: invoker},
+			{Role: invoker, Members: []string{"user:a@example.com"}, Condition: &iamCondition{Title: "c", Expression: "e"}},
+			{Role: "roles/viewer", Members: []string{"user:b@example.com"}},
+		},
+	}
+
+	if err := res.setPolicy(ctx, &policy); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &cloudfunctions.Policy{
+		Etag:    "etag",
+		Version: conditionalPolicyVersion,
+		Bindings: []*cloudfunctions.Binding{
+			{
+				Role:      invoker,
+				Members:   []string{"user:a@example.com"},
+				Condition: &cloudfunctions.Expr{Title: "c", Expression: "e"},
+			},
+			{Role: "roles/viewer", Members: []string{"user:b@example.com"}},
+		},
+	}
+	if !reflect.DeepEqual(got.Policy, want) {
+		t.Errorf("policy = %+v, want %+v", got.Policy, want)
+	}
+}
+
+// fakeIAMResource is an iamResource whose setPolicy returns the errors in
+// setErrors in order, then succeeds.
+type fakeIAMResource struct {
+	policy    iamPolicy
+	setErrors []error
+
+	gets int
+	sets int
+}
+
+func (f *fakeIAMResource) invokerRole() string { return invoker }
+
+func (f *fakeIAMResource) getPolicy(ctx context.Context) (*iamPolicy, error) {
+	f.gets++
+
+	policy := iamPolicy{Etag: f.policy.Etag, Version: f.policy.Version}
+	for _, b := range f.policy.Bindings {
+		binding := *b
+		binding.Members = append([]string(nil), b.Members...)
+		policy.Bindings = append(policy.Bindings, &binding)
+	}
+
+	return &policy, nil
+}
+
+func (f *fakeIAMResource) setPolicy(ctx context.Context, policy *iamPolicy) error {
+	f.sets++
+
+	if len(f.setErrors) > 0 {
+		err := f.setErrors[0]
+		f.setErrors = f.setErrors[1:]
+		return err
+	}
+
+	f.policy = *policy
+
+	return nil
+}
+
+func TestUpdatePolicyRetriesOnConflict(t *testing.T) {
+	res := &fakeIAMResource{
+		setErrors: []error{&googleapi.Error{Code: http.StatusConflict}},
+	}
+
+	err := updatePolicy(context.Background(), res, func(policy *iamPolicy) bool {
+		return len(policy.addMembers(invoker, "allUsers")) > 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.gets != 2 || res.sets != 2 {
+		t.Errorf("got %d reads and %d writes, want 2 of each", res.gets, res.sets)
+	}
+
+	want := []*iamBinding{{Role: invoker, Members: []string{"allUsers"}}}
+	if !reflect.DeepEqual(res.policy.Bindings, want) {
+		t.Errorf("bindings = %+v, want %+v", res.policy.Bindings, want)
+	}
+}
+
+func TestUpdatePolicyDoesNotRetryOtherErrors(t *testing.T) {
+	forbidden := &googleapi.Error{Code: http.StatusForbidden}
+	res := &fakeIAMResource{setErrors: []error{forbidden}}
+
+	err := updatePolicy(context.Background(), res, func(policy *iamPolicy) bool {
+		return len(policy.addMembers(invoker, "allUsers")) > 0
+	})
+	if !errors.Is(err, forbidden) {
+		t.Errorf("err = %v, want %v", err, forbidden)
+	}
+
+	if res.sets != 1 {
+		t.Errorf("got %d writes, want 1", res.sets)
+	}
+}
+
+func TestUpdatePolicyUnchanged(t *testing.T) {
+	res := &fakeIAMResource{
+		policy: iamPolicy{Bindings: []*iamBinding{{Role: invoker, Members: []string{"allUsers"}}}},
+	}
+
+	err := updatePolicy(context.Background(), res, func(policy *iamPolicy) bool {
+		return len(policy.addMembers(invoker, "allUsers")) > 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.sets != 0 {
+		t.Errorf("got %d writes for an unchanged policy, want 0", res.sets)
+	}
+}
@@ -3,9 +3,9 @@ package release
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/waypoint-plugin-sdk/terminal"
-	"google.golang.org/api/cloudfunctions/v1"
 
 	"github.com/sharkyze/waypoint-plugin-cloudfunctions/platform"
 )
@@ -14,6 +14,33 @@ type ReleaseConfig struct {
 	// Unauthenticated, if set to true, will allow unauthenticated access
 	// to your deployment. This defaults to false.
 	Unauthenticated bool `hcl:"unauthenticated,optional"`
+
+	// Invokers are the members allowed to invoke the function, e.g.
+	// "serviceAccount:scheduler@project-id.iam.gserviceaccount.com",
+	// "group:team@example.com", "domain:example.com" or "allAuthenticatedUsers".
+	Invokers []string `hcl:"invokers,optional"`
+
+	// Bindings grant additional roles on the function to members.
+	Bindings []roleBinding `hcl:"binding,block"`
+}
+
+type roleBinding struct {
+	// Role granted to the members, e.g. "roles/cloudfunctions.viewer".
+	Role string `hcl:"role"`
+
+	// Members granted the role, using the same format as Invokers.
+	Members []string `hcl:"members"`
+}
+
+// invokers returns all the members which should be allowed to invoke the
+// function.
+func (c ReleaseConfig) invokers() []string {
+	invokers := c.Invokers
+	if c.Unauthenticated {
+		invokers = append([]string{allUsers}, invokers...)
+	}
+
+	return invokers
 }
 
 type ReleaseManager struct {
@@ -27,13 +54,35 @@ func (rm *ReleaseManager) Config() (interface{}, error) {
 
 // ConfigSet implements component.ConfigurableNotify.
 func (rm *ReleaseManager) ConfigSet(config interface{}) error {
-	_, ok := config.(*ReleaseConfig)
+	c, ok := config.(*ReleaseConfig)
 	if !ok {
 		// The Waypoint SDK should ensure this never gets hit
 		return fmt.Errorf("Expected *ReleaseConfig as parameter")
 	}
 
 	// validate the config
+	for _, m := range c.Invokers {
+		if m == allUsers {
+			return fmt.Errorf("use unauthenticated = true instead of adding %q to invokers", allUsers)
+		}
+
+		if err := validateMember(m); err != nil {
+			return fmt.Errorf("invalid invoker: %w", err)
+		}
+	}
+
+	for _, b := range c.Bindings {
+		if !strings.HasPrefix(b.Role, "roles/") && !strings.HasPrefix(b.Role, "projects/") &&
+			!strings.HasPrefix(b.Role, "organizations/") {
+			return fmt.Errorf("invalid role %q, expected e.g. roles/cloudfunctions.viewer", b.Role)
+		}
+
+		for _, m := range b.Members {
+			if err := validateMember(m); err != nil {
+				return fmt.Errorf("invalid member of binding %q: %w", b.Role, err)
+			}
+		}
+	}
 
 	return nil
 }
@@ -79,64 +128,97 @@ func (rm *ReleaseManager) release(
 	st := ui.Status()
 	defer st.Close()
 
-	release := Release{
-		Version: deployment.Version,
-		Name:    deployment.Name,
-		Url:     deployment.Url,
+	if deployment.ProductionName != "" {
+		promoted, err := platform.Promote(ctx, st, deployment)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := platform.CleanupStaging(ctx, st, deployment); err != nil {
+			st.Step(terminal.StatusWarn, "Unable to delete the stale staging functions: "+err.Error())
+		}
+
+		deployment = promoted
 	}
 
-	// TODO: handle cases where the parameter has changed
-	// 	from Authenticated to Unauthenticated ?
-	if !rm.config.Unauthenticated {
-		st.Step(
-			terminal.StatusOK,
-			"No Operation release, Cloud Function already deployed but only accessible to authenticated users",
-		)
-		return &release, nil
+	release := Release{
+		Version:      deployment.Version,
+		Name:         deployment.Name,
+		Url:          deployment.Url,
+		Generation:   deployment.Generation,
+		Service:      deployment.Service,
+		Revision:     deployment.Revision,
+		DeploymentId: deployment.DeploymentId,
 	}
 
-	st.Update("Releasing Google Cloud Function to all unauthenticated users")
+	st.Update("Updating IAM Policy of Google Cloud Function")
 
-	cloudfunctionsService, err := cloudfunctions.NewService(ctx)
+	res, err := newIAMResource(ctx, &release)
 	if err != nil {
-		st.Step(terminal.StatusError, "Error setting IAM Policy to allUsers")
+		st.Step(terminal.StatusError, "Error updating IAM Policy")
 		return nil, err
 	}
 
-	err = setIAMPolicyAllUsers(ctx, cloudfunctionsService, release.Name)
+	invokers := rm.config.invokers()
+
+	// Public access is only kept if it is explicitly configured.
+	var public []string
+	for _, m := range []string{allUsers, allAuthenticatedUsers} {
+		if !contains(invokers, m) {
+			public = append(public, m)
+		}
+	}
+
+	var granted []*iamBinding
+	var revoked []string
+
+	err = updatePolicy(ctx, res, func(policy *iamPolicy) bool {
+		granted = nil
+
+		if added := policy.addMembers(res.invokerRole(), invokers...); len(added) > 0 {
+			granted = append(granted, &iamBinding{Role: res.invokerRole(), Members: added})
+		}
+
+		for _, b := range rm.config.Bindings {
+			if added := policy.addMembers(b.Role, b.Members...); len(added) > 0 {
+				granted = append(granted, &iamBinding{Role: b.Role, Members: added})
+			}
+		}
+
+		revoked = policy.removeMembers(res.invokerRole(), public...)
+
+		return len(granted) > 0 || len(revoked) > 0
+	})
 	if err != nil {
+		st.Step(terminal.StatusError, "Error updating IAM Policy")
 		return nil, err
 	}
 
-	st.Step(terminal.StatusOK, "IAM Policy successfully set to 'allUsers'")
+	for _, b := range granted {
+		st.Step(terminal.StatusOK, fmt.Sprintf("IAM Policy updated, granted '%s' to %s", b.Role, quoteAll(b.Members)))
 
-	return &release, nil
-}
+		release.AddedBindings = append(release.AddedBindings, &Binding{Role: b.Role, Members: b.Members})
+	}
 
-// setIAMPolicyAllUsers sets the IAM policy on the deployment so that anyone
-// can access it (no auth required).
-func setIAMPolicyAllUsers(
-	ctx context.Context,
-	service *cloudfunctions.Service,
-	name string,
-) error {
-	policyRequest := cloudfunctions.SetIamPolicyRequest{
-		Policy: &cloudfunctions.Policy{
-			Bindings: []*cloudfunctions.Binding{
-				{
-					Role:    "roles/cloudfunctions.invoker",
-					Members: []string{"allUsers"},
-				},
-			},
-		},
+	if len(revoked) > 0 {
+		st.Step(terminal.StatusOK, fmt.Sprintf(
+			"Public access revoked, removed %s from the invokers of the Cloud Function",
+			quoteAll(revoked),
+		))
 	}
 
-	_, err := service.Projects.Locations.Functions.SetIamPolicy(name, &policyRequest).Context(ctx).Do()
-	if err != nil {
-		return err
+	switch {
+	case len(granted) > 0 || len(revoked) > 0:
+	case rm.config.Unauthenticated:
+		st.Step(terminal.StatusOK, "No Operation release, Cloud Function already accessible to all users")
+	default:
+		st.Step(
+			terminal.StatusOK,
+			"No Operation release, Cloud Function already deployed but only accessible to authenticated users",
+		)
 	}
 
-	return nil
+	return &release, nil
 }
 
 // URL implements component.Release.